@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"clutch/common"
@@ -11,14 +16,33 @@ import (
 	"clutch/receiver"
 	"clutch/services"
 	"clutch/services/storage"
+	"clutch/wal"
 )
 
-func Start(websocket bool) receiver.Receiver {
+// Used when the config sets no shutdown_timeout
+const defaultShutdownTimeout = 30 * time.Second
+
+// Clutch is a running instance, Shutdown stops it
+type Clutch struct {
+	receiver *receiver.Receiver
+	// Syslog, file tail and CSV inputs
+	inputs []io.Closer
+	// Stops the services without draining, at the shutdown deadline
+	cancel context.CancelFunc
+	// Closed once the pipeline has drained
+	drained chan struct{}
+	// The error of the listeners when they stop on their own
+	serverErr chan error
+	// nil while the write-ahead log is off
+	wal *wal.Log
+}
+
+// Start loads the config and starts the pipeline, the inputs and the listeners
+func Start() (*Clutch, error) {
 	// Load the base config
 	success, err := config.InitializeConfig()
 	if !success {
-		fmt.Println("Error initializing config:", err)
-		return receiver.Receiver{}
+		return nil, fmt.Errorf("error initializing config: %w", err)
 	}
 
 	// Point to the global config for future steps
@@ -27,35 +51,161 @@ func Start(websocket bool) receiver.Receiver {
 
 	store, err := storage.InitializeStore(&cfg.Database)
 	if err != nil {
-		fmt.Println("Error initializing store:", err)
-		return receiver.Receiver{}
+		return nil, fmt.Errorf("error initializing store: %w", err)
 	}
 	// Update common config with the store config
 	cfg.SetStoreConfig(store)
 
-	// Start the services
-	go services.Start(&common.Pipeline)
+	// Start the services, they outlive the signal until the pipeline has drained
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Clutch{cancel: cancel, drained: make(chan struct{}), serverErr: make(chan error, 1)}
+	go func() {
+		services.Start(ctx, &common.Pipeline)
+		close(c.drained)
+	}()
 
 	r := receiver.NewReceiver()
+	if err := r.LoadAPIKeys(cfg.Auth); err != nil {
+		cancel()
+		return nil, fmt.Errorf("error loading API keys: %w", err)
+	}
+	r.LoadRateLimits(cfg.RateLimits)
+	c.receiver = r
+	if cfg.WAL.Dir != "" {
+		c.wal, err = wal.Open(cfg.WAL)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("error opening write-ahead log: %w", err)
+		}
+		r.UseWAL(c.wal)
+	}
 	// Start the receiver
 	r.Receive()
+	// What the last run did not finish goes first
+	if err := r.Replay(); err != nil {
+		fmt.Println("Error replaying write-ahead log:", err)
+	}
+
+	// Start the inputs that do not go through the WebSocket server
+	if cfg.Inputs.Syslog.UDP != "" || cfg.Inputs.Syslog.TCP != "" {
+		syslog := receiver.NewSyslogReceiver(&cfg.Inputs.Syslog)
+		syslog.UseWAL(c.wal)
+		if err := syslog.Start(); err != nil {
+			fmt.Println("Error starting syslog receiver:", err)
+		} else {
+			c.inputs = append(c.inputs, syslog)
+		}
+	}
+	for i := range cfg.Inputs.Tail {
+		tailer := receiver.NewFileTailer(&cfg.Inputs.Tail[i])
+		tailer.UseWAL(c.wal)
+		if err := tailer.Start(); err != nil {
+			fmt.Println("Error starting file tail:", err)
+		} else {
+			c.inputs = append(c.inputs, tailer)
+		}
+	}
+	for i := range cfg.Inputs.CSV {
+		if len(cfg.Inputs.CSV[i].Paths) == 0 {
+			continue // upload only
+		}
+		input := receiver.NewCSVFileInput(&cfg.Inputs.CSV[i])
+		input.UseWAL(c.wal)
+		if err := input.Start(); err != nil {
+			fmt.Println("Error starting csv input:", err)
+		} else {
+			c.inputs = append(c.inputs, input)
+		}
+	}
+
+	if cfg.Server.Disabled {
+		fmt.Println("HTTP listeners disabled (server.disabled)")
+	} else {
+		go func() {
+			c.serverErr <- r.StartServers(cfg.Server)
+		}()
+	}
+	return c, nil
+}
+
+// Shutdown stops accepting events and waits until everything in flight has gone
+// through the pipeline, or the timeout passes and the services are stopped as they are
+func (c *Clutch) Shutdown(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	fmt.Println("Shutting down, waiting up to", timeout)
+
+	if err := c.receiver.Shutdown(ctx); err != nil {
+		// Requests may still be queueing events, the queue can not be closed
+		c.cancel()
+		return err
+	}
+	for _, input := range c.inputs {
+		if err := input.Close(); err != nil {
+			fmt.Println("Error closing input:", err)
+		}
+	}
+	go c.receiver.Close()
 
-	if websocket {
-		fmt.Println("Starting WebSocket server on :8080")
-		if err := r.StartServer(":8080"); err != nil {
-			log.Fatal("ListenAndServe: ", err)
+	select {
+	case <-c.drained:
+	case <-ctx.Done():
+		c.cancel()
+		c.closeWAL()
+		return fmt.Errorf("events still in flight: %w", ctx.Err())
+	}
+	c.cancel()
+	c.closeWAL()
+	if closer, ok := common.GetConfig().Store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			fmt.Println("Error closing store:", err)
 		}
 	}
-	return *r
+	fmt.Println("Shutdown complete")
+	return nil
+}
+
+// closeWAL keeps the events that were not committed for the next start
+func (c *Clutch) closeWAL() {
+	if c.wal == nil {
+		return
+	}
+	if err := c.wal.Close(); err != nil {
+		fmt.Println("Error closing write-ahead log:", err)
+	}
 }
 
 func main() {
-	// Start the receiver (websocket / chat)
-	START_WEBSOCKET := false
-	reciever := Start(START_WEBSOCKET)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	fmt.Println("Reciever:", &reciever)
+	clutch, err := Start()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 	fmt.Println("Services are up and running...")
+	testQueries()
+
+	select {
+	case <-ctx.Done():
+	case err := <-clutch.serverErr:
+		fmt.Println("Listener stopped:", err)
+	}
+	// A second signal kills the process right away
+	stop()
+
+	timeout := common.GetConfig().ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	if err := clutch.Shutdown(timeout); err != nil {
+		log.Fatal("Shutdown incomplete: ", err)
+	}
+}
+
+// testQueries checks the store and the model against the testing events
+func testQueries() {
 	// sleep for 10 seconds for things to
 	time.Sleep(5 * time.Second)
 
@@ -103,12 +253,10 @@ func main() {
 	fmt.Println("AI results:", s)
 
 	// // Start the WebSocket here if you need to test events coming in and shit manually
-	// fmt.Println("Starting WebSocket server on :8080")
-	// if err := reciever.StartServer(":8080"); err != nil {
+	// fmt.Println("Starting WebSocket server")
+	// if err := reciever.StartServers(cfg.Server); err != nil {
 	// 	log.Fatal("ListenAndServe: ", err)
 	// }
-
-	return
 }
 
 // func DeleteIndex(c common.Store, index string) {
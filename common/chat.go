@@ -0,0 +1,163 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Open chat sessions. The /chat handler asks the questions, the chat service answers
+// them on the session the question names.
+var (
+	sessions      = make(map[string]*Session)
+	sessionsMutex sync.Mutex
+
+	ErrSlowChatClient  = errors.New("chat client too slow, replies were dropped")
+	errSessionFinished = errors.New("chat session closed")
+)
+
+// Replies a session can have waiting before its client counts as too slow
+const sessionBuffer = 1024
+
+// Turn is a single question and answer of a conversation
+type Turn struct {
+	Question string
+	Answer   string
+}
+
+// Reply types sent back to the chat client
+const (
+	TokenReply = "token"
+	DoneReply  = "done"
+	ErrorReply = "error"
+)
+
+// Reply is sent back to the chat client. Answers arrive as a series of token
+// replies followed by a done reply carrying the full answer and its sources.
+type Reply struct {
+	Type     string                   `json:"type"`
+	Question string                   `json:"question,omitempty"`
+	Token    string                   `json:"token,omitempty"`
+	Answer   string                   `json:"answer,omitempty"`
+	Sources  []map[string]interface{} `json:"sources,omitempty"`
+	Error    string                   `json:"error,omitempty"`
+}
+
+// Session holds the conversation of a single chat connection
+type Session struct {
+	ID      string
+	Index   string
+	Replies chan Reply
+
+	mu      sync.Mutex
+	history []Turn
+	done    chan struct{}
+	once    sync.Once
+	err     error
+}
+
+// NewSession registers a conversation so chat events can be answered on it
+func NewSession(index string) *Session {
+	session := &Session{
+		ID:      uuid.NewString(),
+		Index:   index,
+		Replies: make(chan Reply, sessionBuffer),
+		done:    make(chan struct{}),
+	}
+	sessionsMutex.Lock()
+	sessions[session.ID] = session
+	sessionsMutex.Unlock()
+	return session
+}
+
+// GetSession returns the open session with the given id
+func GetSession(id string) (*Session, bool) {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	session, ok := sessions[id]
+	return session, ok
+}
+
+// Close unregisters the session and stops pending sends
+func (s *Session) Close() {
+	s.close(errSessionFinished)
+}
+
+func (s *Session) close(err error) {
+	sessionsMutex.Lock()
+	delete(sessions, s.ID)
+	sessionsMutex.Unlock()
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+// Done is closed once the session has been closed or dropped
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err tells why the session ended, ErrSlowChatClient when it was dropped
+func (s *Session) Err() error {
+	<-s.done
+	return s.err
+}
+
+// Send delivers a reply without ever blocking the chat service, which answers every
+// session. A client that falls behind is dropped. Send returns false once the
+// session is closed.
+func (s *Session) Send(reply Reply) bool {
+	// Check first, select picks at random when the buffer has room too
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+	select {
+	case s.Replies <- reply:
+		return true
+	default:
+		fmt.Println("Dropping slow chat client:", s.ID)
+		s.close(ErrSlowChatClient)
+		return false
+	}
+}
+
+// History returns the turns kept for the conversation, oldest first
+func (s *Session) History() []Turn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Turn(nil), s.history...)
+}
+
+// Remember adds a turn, keeping the last limit of them
+func (s *Session) Remember(turn Turn, limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history = append(s.history, turn)
+	if len(s.history) > limit {
+		s.history = s.history[len(s.history)-limit:]
+	}
+}
+
+// ChatEventType is the type of the questions asked on /chat, the distributor hands
+// them to the chat service. Clients can not send it to the other endpoints.
+const ChatEventType = "chat"
+
+// NewChatEvent wraps a question so it can travel through the pipeline
+func NewChatEvent(session *Session, question string, index string) Event {
+	if index == "" {
+		index = session.Index
+	}
+	return Event{
+		Type: ChatEventType,
+		Payload: M{
+			"session_id": session.ID,
+			"question":   question,
+			"index":      index,
+		},
+	}
+}
@@ -3,6 +3,10 @@ package common // or your main package name
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 var (
@@ -11,14 +15,6 @@ var (
 	EventChan = make(chan Event, 1000)
 	// All events go through the pipeline
 	Pipeline = make(chan Event, 1000)
-	// Masking Channel for masking events
-	MaskChan = make(chan Event, 1000)
-	// Storage Channel for storing events
-	StorageChan = make(chan Event, 1000)
-	// Masked Storage Channel for storing masked events
-	MaskedStorageChan = make(chan Event, 1000)
-	// Synthesis Channel for synthesis events
-	SynthChan = make(chan Event, 1000)
 	// Chat Channel for chat events
 	ChatChan = make(chan Event, 1000)
 
@@ -38,6 +34,8 @@ type M map[string]interface{}
 type ModelInterface interface {
 	GenerateEmbeddings(text string) ([][]float32, error)
 	QueryWithContext(query string, ctx string) (string, error)
+	// QueryWithContextStream calls onToken for every generated token and returns the full answer
+	QueryWithContextStream(query string, ctx string, onToken func(token string) error) (string, error)
 	Start()
 	GetModelName() string
 }
@@ -61,6 +59,85 @@ type Masker interface {
 type Event struct {
 	Type    string
 	Payload M
+	// Set by the receiver, clients cannot send it
+	Meta EventMeta `json:"-"`
+}
+
+// EventMeta is the envelope of an event, where and when it was received
+type EventMeta struct {
+	ID         string
+	ReceivedAt time.Time
+	// Listener or input the event arrived on
+	Listener   string
+	RemoteAddr string
+	// Client certificate or API key the event was sent with
+	Identity string
+	// Position in the write-ahead log, 0 when the event is not logged
+	Seq uint64
+	// Shared by the event and the copies the stages make of it, see Pending
+	Pending *Pending
+}
+
+// Stored documents keep the envelope under this key, next to the payload fields
+const MetaField = "clutch"
+
+// NewEventMeta stamps an event received now with a new id
+func NewEventMeta(listener string, remoteAddr string) EventMeta {
+	return EventMeta{
+		ID:         uuid.NewString(),
+		ReceivedAt: time.Now().UTC(),
+		Listener:   listener,
+		RemoteAddr: remoteAddr,
+	}
+}
+
+// Pending counts the holders of a logged event, commit runs when the last one is
+// done with it. Whoever hands the event (or a copy) to someone else adds to the
+// count first. A nil Pending ignores both, so events that are not logged need nothing.
+type Pending struct {
+	count  atomic.Int64
+	commit func()
+}
+
+// NewPending starts with one holder, the caller
+func NewPending(commit func()) *Pending {
+	p := &Pending{commit: commit}
+	p.count.Store(1)
+	return p
+}
+
+func (p *Pending) Add(holders int) {
+	if p != nil {
+		p.count.Add(int64(holders))
+	}
+}
+
+func (p *Pending) Done() {
+	if p != nil && p.count.Add(-1) == 0 {
+		p.commit()
+	}
+}
+
+// Document returns the payload as it is stored, a copy with the envelope added
+func (e Event) Document() M {
+	doc := make(M, len(e.Payload)+1)
+	for k, v := range e.Payload {
+		doc[k] = v
+	}
+	if e.Meta.ID == "" {
+		return doc
+	}
+	meta := map[string]interface{}{
+		"id":          e.Meta.ID,
+		"received_at": e.Meta.ReceivedAt.Format(time.RFC3339Nano),
+	}
+	for key, value := range map[string]string{"listener": e.Meta.Listener, "remote_addr": e.Meta.RemoteAddr, "identity": e.Meta.Identity} {
+		if value != "" {
+			meta[key] = value
+		}
+	}
+	doc[MetaField] = meta
+	return doc
 }
 
 type MaskConfig struct {
@@ -68,6 +145,16 @@ type MaskConfig struct {
 	Operations  []MaskOperation `yaml:"masks"`
 }
 
+// ParseConfig extracts fields from a string field of an event. Format is "grok"
+// (the default), "cef" or "leef". Grok patterns are tried in order, the first
+// match wins. Definitions adds named patterns on top of the built-in library.
+type ParseConfig struct {
+	Field       string            `yaml:"field"`
+	Format      string            `yaml:"format"`
+	Patterns    []string          `yaml:"patterns"`
+	Definitions map[string]string `yaml:"pattern_definitions"`
+}
+
 type MaskOperation struct {
 	Key      string `yaml:"key"`
 	Operator string `yaml:"operator"`
@@ -75,9 +162,34 @@ type MaskOperation struct {
 	Type     string `yaml:"type"`
 }
 
+// ServerConfig is the default listener on Host:Port. Once Listeners are set they
+// replace it, each listener uses the TLS settings here unless it has its own.
 type ServerConfig struct {
-	Host string `yaml:"host"`
-	Port string `yaml:"port"`
+	Host      string           `yaml:"host"`
+	Port      string           `yaml:"port"`
+	TLS       TLSConfig        `yaml:"tls"`
+	Listeners []ListenerConfig `yaml:"listeners"`
+	// No HTTP listeners at all, only the syslog, file tail and CSV inputs
+	Disabled bool `yaml:"disabled"`
+}
+
+// ListenerConfig is a named server with its own address. Handlers lists the groups
+// of endpoints it serves ("websocket", "ingest", "chat", ...), empty serves all of them.
+type ListenerConfig struct {
+	Name     string     `yaml:"name"`
+	Host     string     `yaml:"host"`
+	Port     string     `yaml:"port"`
+	Handlers []string   `yaml:"handlers"`
+	TLS      *TLSConfig `yaml:"tls"`
+}
+
+// TLSConfig serves https:// and wss:// once Cert and Key are set. With ClientCA every
+// client must present a certificate signed by it. MinVersion is "1.2" (the default) or "1.3".
+type TLSConfig struct {
+	Cert       string `yaml:"cert"`
+	Key        string `yaml:"key"`
+	ClientCA   string `yaml:"client_ca"`
+	MinVersion string `yaml:"min_version"`
 }
 
 // Struct to represent the Database section
@@ -99,15 +211,140 @@ type BaseModelConfig struct {
 	BasePrompt        string `yaml:"base_prompt"`
 }
 
+// SyslogConfig enables the syslog listeners, leave an address empty to disable it
+type SyslogConfig struct {
+	UDP  string `yaml:"udp"`
+	TCP  string `yaml:"tcp"`
+	Type string `yaml:"type"`
+}
+
+// TailConfig follows files matching Paths and emits each line as an event of Type.
+// Format is "json", "text" or empty to keep JSON objects and wrap other lines.
+type TailConfig struct {
+	Paths        []string      `yaml:"paths"`
+	Type         string        `yaml:"type"`
+	Format       string        `yaml:"format"`
+	Checkpoint   string        `yaml:"checkpoint"`
+	PollInterval time.Duration `yaml:"poll_interval"`
+}
+
+// CSVConfig describes delimited input of one event Type. Files matching Paths are
+// read once they stop growing, uploads to /events/csv?type=<Type> use the same settings.
+// Columns maps header names to dotted payload paths, e.g. "yield_kg: harvest.yield".
+type CSVConfig struct {
+	Paths        []string          `yaml:"paths"`
+	Type         string            `yaml:"type"`
+	Delimiter    string            `yaml:"delimiter"`
+	Columns      map[string]string `yaml:"columns"`
+	Checkpoint   string            `yaml:"checkpoint"`
+	PollInterval time.Duration     `yaml:"poll_interval"`
+}
+
+// OTLPConfig sets the event type of logs received on /v1/logs
+type OTLPConfig struct {
+	Type string `yaml:"type"`
+}
+
+// HECConfig holds the tokens accepted on the Splunk HEC endpoint. Events without
+// a sourcetype get Type, the endpoint rejects everything while no token is set.
+type HECConfig struct {
+	Tokens []string `yaml:"tokens"`
+	Type   string   `yaml:"type"`
+}
+
+// InputsConfig holds the settings of the ingest paths besides /ws
+type InputsConfig struct {
+	Syslog SyslogConfig `yaml:"syslog"`
+	Tail   []TailConfig `yaml:"tail"`
+	OTLP   OTLPConfig   `yaml:"otlp"`
+	HEC    HECConfig    `yaml:"hec"`
+	CSV    []CSVConfig  `yaml:"csv"`
+}
+
+// APIKeyConfig is a client key, stored as the hex encoded sha256 of the key. EventTypes
+// are patterns ("sensor_*") of the types the key may publish, empty allows every type.
+// RateLimit replaces the per key limit of the rate_limits config for this key.
+type APIKeyConfig struct {
+	Name       string     `yaml:"name"`
+	Hash       string     `yaml:"hash"`
+	EventTypes []string   `yaml:"event_types"`
+	RateLimit  *RateLimit `yaml:"rate_limit"`
+}
+
+// RateLimit is a token bucket refilled with Rate events per second that holds up to
+// Burst events (Rate rounded up when unset). A zero Rate does not limit anything.
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// RateLimitConfig limits how fast a single connection and a single API key can send events
+type RateLimitConfig struct {
+	Connection RateLimit `yaml:"per_connection"`
+	APIKey     RateLimit `yaml:"per_key"`
+}
+
+// AuthConfig turns on API key checks for /ws, /chat and the HTTP ingest endpoints
+// as soon as a key is set, either here or in KeyFile.
+type AuthConfig struct {
+	Keys    []APIKeyConfig `yaml:"keys"`
+	KeyFile string         `yaml:"key_file"`
+}
+
+// PipelineConfig wires the stages every non-chat event flows through. Events enter
+// the stages no edge leads to.
+type PipelineConfig struct {
+	Stages []StageConfig `yaml:"stages"`
+	Edges  []EdgeConfig  `yaml:"edges"`
+}
+
+// StageConfig runs a service as a named stage, Types (glob patterns) limits the
+// event types sent to it. Options are handed to the service as they are.
+type StageConfig struct {
+	Name    string                 `yaml:"name"`
+	Service string                 `yaml:"service"`
+	Types   []string               `yaml:"types"`
+	Options map[string]interface{} `yaml:"options"`
+}
+
+// EdgeConfig sends the output of a stage to the next ones
+type EdgeConfig struct {
+	From string   `yaml:"from"`
+	To   []string `yaml:"to"`
+}
+
+// WALConfig turns on the write-ahead log when Dir is set. A new segment file is
+// started once the current one reaches SegmentSize bytes, Sync flushes every event to
+// disk before it is acknowledged.
+type WALConfig struct {
+	Dir         string `yaml:"dir"`
+	SegmentSize int64  `yaml:"segment_size"`
+	Sync        bool   `yaml:"sync"`
+}
+
+type ChatConfig struct {
+	Index        string `yaml:"index"`
+	MaxDocuments int    `yaml:"max_documents"`
+	HistoryTurns int    `yaml:"history_turns"`
+}
+
 // Struct to represent the full configuration
 type Config struct {
-	Server      ServerConfig          `yaml:"server"`
-	Database    DatabaseConfig        `yaml:"database"`
-	Services    []string              `yaml:"services"`
-	Masks       map[string]MaskConfig `yaml:"masks"`
-	ModelConfig BaseModelConfig       `yaml:"model"`
-	Model       ModelInterface        `yaml:"-"`
-	Store       Store                 `yaml:"-"`
+	Server          ServerConfig           `yaml:"server"`
+	Database        DatabaseConfig         `yaml:"database"`
+	Services        []string               `yaml:"services"`
+	Masks           map[string]MaskConfig  `yaml:"masks"`
+	Parsers         map[string]ParseConfig `yaml:"parsers"`
+	ModelConfig     BaseModelConfig        `yaml:"model"`
+	Chat            ChatConfig             `yaml:"chat"`
+	Inputs          InputsConfig           `yaml:"inputs"`
+	Auth            AuthConfig             `yaml:"auth"`
+	RateLimits      RateLimitConfig        `yaml:"rate_limits"`
+	Pipeline        PipelineConfig         `yaml:"pipeline"`
+	ShutdownTimeout time.Duration          `yaml:"shutdown_timeout"`
+	WAL             WALConfig              `yaml:"wal"`
+	Model           ModelInterface         `yaml:"-"`
+	Store           Store                  `yaml:"-"`
 }
 
 func GetConfigAddress() *Config {
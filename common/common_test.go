@@ -1,9 +1,11 @@
 package common
 
 import (
+	"context"
 	"os"
 	"reflect"
 	"testing"
+	"time"
 )
 
 // Mock implementations for interfaces
@@ -13,6 +15,12 @@ func (m *MockModelInterface) GenerateEmbeddings(text string) ([][]float32, error
 func (m *MockModelInterface) QueryWithContext(query string, ctx string) (string, error) {
 	return "NICE!", nil
 }
+func (m *MockModelInterface) QueryWithContextStream(query string, ctx string, onToken func(token string) error) (string, error) {
+	if err := onToken("NICE!"); err != nil {
+		return "", err
+	}
+	return "NICE!", nil
+}
 func (m *MockModelInterface) Start()               {}
 func (m *MockModelInterface) GetModelName() string { return "mock_model" }
 
@@ -185,3 +193,106 @@ func TestFlattenMapPrefix(t *testing.T) {
 		t.Errorf("FlattenMap() = %v, want %v", result, expected)
 	}
 }
+
+func TestEventDocument(t *testing.T) {
+	event := Event{Type: "a", Payload: M{"foo": "bar", MetaField: "spoofed"}}
+	if doc := event.Document(); !reflect.DeepEqual(doc, event.Payload) {
+		t.Errorf("Document() without an envelope = %v, want the payload", doc)
+	}
+
+	event.Meta = NewEventMeta("internal", "10.0.0.7:51234")
+	event.Meta.ReceivedAt = time.Date(2024, 10, 12, 6, 25, 24, 0, time.UTC)
+	doc := event.Document()
+	expected := map[string]interface{}{
+		"id":          event.Meta.ID,
+		"received_at": "2024-10-12T06:25:24Z",
+		"listener":    "internal",
+		"remote_addr": "10.0.0.7:51234",
+	}
+	if !reflect.DeepEqual(doc[MetaField], expected) {
+		t.Errorf("Document()[%s] = %v, want %v", MetaField, doc[MetaField], expected)
+	}
+	if doc["foo"] != "bar" || event.Payload[MetaField] != "spoofed" {
+		t.Errorf("Document() should copy the payload, got %v", doc)
+	}
+}
+
+type nopService struct{}
+
+func (nopService) Name() string                                                     { return "nop" }
+func (nopService) Init(cfg StageConfig) error                                       { return nil }
+func (nopService) Run(ctx context.Context, in <-chan Event, out chan<- Event) error { return nil }
+func (nopService) Close() error                                                     { return nil }
+
+// registerTestService registers a service for the length of the test, so -count=2
+// does not register it twice
+func registerTestService(t *testing.T, name string, factory func() Service) {
+	t.Helper()
+	RegisterService(name, factory)
+	t.Cleanup(func() {
+		registryMutex.Lock()
+		defer registryMutex.Unlock()
+		delete(registry, name)
+	})
+}
+
+func TestServiceRegistry(t *testing.T) {
+	registerTestService(t, "nop", func() Service { return nopService{} })
+	if service, ok := NewService("nop"); !ok || service.Name() != "nop" {
+		t.Errorf("NewService(nop) = %v, %v", service, ok)
+	}
+	if _, ok := NewService("missing"); ok {
+		t.Errorf("NewService(missing) should fail")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering a name twice should panic")
+		}
+	}()
+	RegisterService("nop", func() Service { return nopService{} })
+}
+
+func TestProcessEvents(t *testing.T) {
+	in, out := make(chan Event, 3), make(chan Event, 6)
+	in <- Event{Type: "a"}
+	in <- Event{Type: "drop"}
+	in <- Event{Type: "b"}
+	close(in)
+	err := ProcessEvents(context.Background(), in, out, func(event Event) []Event {
+		if event.Type == "drop" {
+			return nil
+		}
+		return []Event{event, event}
+	})
+	if err != nil || len(out) != 4 {
+		t.Errorf("ProcessEvents() = %v with %d events out", err, len(out))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ProcessEvents(ctx, make(chan Event), out, nil); err != context.Canceled {
+		t.Errorf("ProcessEvents() after cancel = %v", err)
+	}
+}
+
+func TestSessionDropsSlowClient(t *testing.T) {
+	session := NewSession("")
+	for i := 0; i < cap(session.Replies); i++ {
+		if !session.Send(Reply{Type: TokenReply}) {
+			t.Fatalf("reply %d was not sent", i)
+		}
+	}
+	// The buffer is full, the sender does not wait for the client
+	if session.Send(Reply{Type: DoneReply}) {
+		t.Fatal("Send() = true on a full buffer")
+	}
+	if err := session.Err(); err != ErrSlowChatClient {
+		t.Errorf("Err() = %v, want %v", err, ErrSlowChatClient)
+	}
+	if _, ok := GetSession(session.ID); ok {
+		t.Errorf("slow session is still registered")
+	}
+	if session.Send(Reply{Type: TokenReply}) {
+		t.Errorf("Send() = true after the session was dropped")
+	}
+}
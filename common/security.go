@@ -0,0 +1,199 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Security event formats, also the parser formats that read them
+const (
+	CEFFormat  = "cef"
+	LEEFFormat = "leef"
+)
+
+var (
+	ErrNotSecurityEvent = errors.New("message is not CEF or LEEF")
+
+	cefHeaderFields  = []string{"cef_version", "device_vendor", "device_product", "device_version", "signature_id", "name", "severity"}
+	leefHeaderFields = []string{"leef_version", "device_vendor", "device_product", "device_version", "event_id"}
+)
+
+// ParseSecurityEvent parses CEF and LEEF messages, anything else returns ErrNotSecurityEvent
+func ParseSecurityEvent(message string) (M, error) {
+	switch {
+	case strings.HasPrefix(message, "CEF:"):
+		return ParseCEF(message)
+	case strings.HasPrefix(message, "LEEF:"):
+		return ParseLEEF(message)
+	}
+	return nil, ErrNotSecurityEvent
+}
+
+// AddSecurityFields copies parsed CEF or LEEF fields into the payload. Fields the
+// payload already has are kept, the sender of the message picks the names.
+func AddSecurityFields(payload M, fields M) {
+	for key, value := range fields {
+		if _, ok := payload[key]; !ok {
+			payload[key] = value
+		}
+	}
+}
+
+// ParseCEF parses an ArcSight Common Event Format message. Header fields and
+// extensions all become top level string fields, so masks can reach them.
+func ParseCEF(message string) (M, error) {
+	rest, ok := strings.CutPrefix(message, "CEF:")
+	if !ok {
+		return nil, fmt.Errorf("missing CEF: prefix")
+	}
+
+	// Pipes and backslashes are escaped in the header
+	var header []string
+	var field strings.Builder
+	i := 0
+	for ; i < len(rest) && len(header) < len(cefHeaderFields); i++ {
+		switch {
+		case rest[i] == '\\' && i+1 < len(rest) && (rest[i+1] == '|' || rest[i+1] == '\\'):
+			i++
+			field.WriteByte(rest[i])
+		case rest[i] == '|':
+			header = append(header, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(rest[i])
+		}
+	}
+	// Tolerate a missing pipe after the severity when there are no extensions
+	if len(header) == len(cefHeaderFields)-1 && i == len(rest) {
+		header = append(header, field.String())
+	}
+	if len(header) < len(cefHeaderFields) {
+		return nil, fmt.Errorf("CEF header has %d of %d fields", len(header), len(cefHeaderFields))
+	}
+
+	payload := M{}
+	for key, value := range parseCEFExtension(rest[i:]) {
+		payload[key] = value
+	}
+	for j, name := range cefHeaderFields {
+		payload[name] = strings.TrimSpace(header[j])
+	}
+	return payload, nil
+}
+
+// parseCEFExtension splits "key=value key2=value with spaces" pairs. A value runs
+// until the space before the next unescaped key=.
+func parseCEFExtension(extension string) map[string]string {
+	type pair struct {
+		keyStart, valueStart int
+	}
+	var pairs []pair
+	for i := 0; i < len(extension); i++ {
+		if extension[i] == '\\' {
+			i++
+			continue
+		}
+		if extension[i] != '=' {
+			continue
+		}
+		keyStart := strings.LastIndexByte(extension[:i], ' ') + 1
+		if keyStart < i && (len(pairs) == 0 || keyStart > pairs[len(pairs)-1].valueStart) {
+			pairs = append(pairs, pair{keyStart: keyStart, valueStart: i + 1})
+		}
+	}
+
+	fields := make(map[string]string, len(pairs))
+	for j, p := range pairs {
+		end := len(extension)
+		if j+1 < len(pairs) {
+			end = pairs[j+1].keyStart
+		}
+		key := extension[p.keyStart : p.valueStart-1]
+		fields[key] = unescapeCEFValue(strings.TrimSpace(extension[p.valueStart:end]))
+	}
+	return fields
+}
+
+func unescapeCEFValue(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 == len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+	return b.String()
+}
+
+// ParseLEEF parses an IBM QRadar Log Event Extended Format 1.0 or 2.0 message.
+// Attributes are tab separated unless a 2.0 header names another delimiter.
+func ParseLEEF(message string) (M, error) {
+	rest, ok := strings.CutPrefix(message, "LEEF:")
+	if !ok {
+		return nil, fmt.Errorf("missing LEEF: prefix")
+	}
+
+	headerCount := len(leefHeaderFields)
+	if strings.HasPrefix(rest, "2") {
+		headerCount++ // the delimiter
+	}
+	parts := strings.SplitN(rest, "|", headerCount+1)
+	if len(parts) < headerCount {
+		return nil, fmt.Errorf("LEEF header has %d of %d fields", len(parts), headerCount)
+	}
+
+	delimiter := "\t"
+	if headerCount > len(leefHeaderFields) {
+		var err error
+		if delimiter, err = leefDelimiter(parts[len(leefHeaderFields)]); err != nil {
+			return nil, err
+		}
+	}
+
+	payload := M{}
+	if len(parts) > headerCount {
+		for _, attribute := range strings.Split(parts[headerCount], delimiter) {
+			key, value, found := strings.Cut(attribute, "=")
+			if key = strings.TrimSpace(key); found && key != "" {
+				payload[key] = strings.TrimSpace(value)
+			}
+		}
+	}
+	for j, name := range leefHeaderFields {
+		payload[name] = strings.TrimSpace(parts[j])
+	}
+	return payload, nil
+}
+
+// leefDelimiter reads the delimiter of a LEEF 2.0 header, a single character or its hex code ("x09", "0x09")
+func leefDelimiter(field string) (string, error) {
+	switch len(field) {
+	case 0:
+		return "\t", nil
+	case 1:
+		return field, nil
+	}
+	lower := strings.ToLower(field)
+	for _, prefix := range []string{"0x", "x"} {
+		if hex, ok := strings.CutPrefix(lower, prefix); ok {
+			if code, err := strconv.ParseUint(hex, 16, 8); err == nil {
+				return string(rune(code)), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("invalid LEEF delimiter %q", field)
+}
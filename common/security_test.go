@@ -0,0 +1,92 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCEF(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    M
+	}{
+		{
+			name:    "extensions with spaces and escapes",
+			message: `CEF:0|Security|threat\|manager|1.0|100|worm successfully stopped|10|src=10.0.0.1 dst=2.1.2.2 msg=Detected a threat. No action needed. request=http://x/?a\=b cs1=C:\\temp\nnext`,
+			want: M{
+				"cef_version": "0", "device_vendor": "Security", "device_product": "threat|manager",
+				"device_version": "1.0", "signature_id": "100", "name": "worm successfully stopped", "severity": "10",
+				"src": "10.0.0.1", "dst": "2.1.2.2", "msg": "Detected a threat. No action needed.",
+				"request": "http://x/?a=b", "cs1": "C:\\temp\nnext",
+			},
+		},
+		{
+			name:    "no extensions and no trailing pipe",
+			message: `CEF:1|Vendor|Product|2|sig|Name|High`,
+			want: M{
+				"cef_version": "1", "device_vendor": "Vendor", "device_product": "Product",
+				"device_version": "2", "signature_id": "sig", "name": "Name", "severity": "High",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSecurityEvent(tt.message)
+			if err != nil {
+				t.Fatalf("ParseSecurityEvent() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("payload = %v\nwant %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLEEF(t *testing.T) {
+	header := M{"device_vendor": "Lancope", "device_product": "StealthWatch", "device_version": "1.0", "event_id": "41"}
+	tests := []struct {
+		name    string
+		message string
+		version string
+	}{
+		{"1.0 tabs", "LEEF:1.0|Lancope|StealthWatch|1.0|41|src=10.0.1.8\tdst=10.0.0.5\tusrName=joe smith", "1.0"},
+		{"2.0 caret", "LEEF:2.0|Lancope|StealthWatch|1.0|41|^|src=10.0.1.8^dst=10.0.0.5^usrName=joe smith", "2.0"},
+		{"2.0 hex", "LEEF:2.0|Lancope|StealthWatch|1.0|41|x09|src=10.0.1.8\tdst=10.0.0.5\tusrName=joe smith", "2.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSecurityEvent(tt.message)
+			if err != nil {
+				t.Fatalf("ParseSecurityEvent() error = %v", err)
+			}
+			want := M{"leef_version": tt.version, "src": "10.0.1.8", "dst": "10.0.0.5", "usrName": "joe smith"}
+			for key, value := range header {
+				want[key] = value
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("payload = %v\nwant %v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseSecurityEventErrors(t *testing.T) {
+	if _, err := ParseSecurityEvent("plain text"); err != ErrNotSecurityEvent {
+		t.Errorf("plain text error = %v, want ErrNotSecurityEvent", err)
+	}
+	for _, message := range []string{"CEF:0|Vendor|Product", "LEEF:1.0|Vendor", "LEEF:2.0|V|P|1|41|abc|a=b"} {
+		if _, err := ParseSecurityEvent(message); err == nil || err == ErrNotSecurityEvent {
+			t.Errorf("ParseSecurityEvent(%q) error = %v, want a parse error", message, err)
+		}
+	}
+}
+
+func TestAddSecurityFields(t *testing.T) {
+	payload := M{"severity": "info", "host": "fw01"}
+	AddSecurityFields(payload, M{"severity": "10", "host": "attacker", "src": "10.0.0.1"})
+	want := M{"severity": "info", "host": "fw01", "src": "10.0.0.1"}
+	if !reflect.DeepEqual(payload, want) {
+		t.Errorf("payload = %v\nwant %v", payload, want)
+	}
+}
@@ -0,0 +1,85 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Service is a pipeline stage. Every stage gets its own instance: Init is called once
+// with the stage config, Run reads in until it is closed (or ctx is cancelled) and
+// writes what it passes on to out, Close releases whatever Init acquired. Run must
+// not close out. Run lets go of every event it reads with Meta.Pending.Done() once it is
+// done with it, and holds what it writes to out first, see Pending. ProcessEvents
+// does both.
+type Service interface {
+	Name() string
+	Init(cfg StageConfig) error
+	Run(ctx context.Context, in <-chan Event, out chan<- Event) error
+	Close() error
+}
+
+var (
+	registry      = make(map[string]func() Service)
+	registryMutex sync.RWMutex
+)
+
+// RegisterService makes a service available to the pipeline config under name,
+// usually from the init function of its package
+func RegisterService(name string, factory func() Service) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("service %q registered twice", name))
+	}
+	registry[name] = factory
+}
+
+// NewService returns a new instance of a registered service
+func NewService(name string) (Service, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	factory, ok := registry[name]
+	if !ok {
+		return nil, false
+	}
+	return factory(), true
+}
+
+// Services lists the registered service names
+func Services() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProcessEvents is the Run loop of services handling one event at a time, the events
+// process returns are passed on
+func ProcessEvents(ctx context.Context, in <-chan Event, out chan<- Event, process func(event Event) []Event) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-in:
+			if !ok {
+				return nil
+			}
+			for _, result := range process(event) {
+				// The result may be the event itself, so it is held before the event is let go
+				result.Meta.Pending.Add(1)
+				select {
+				case out <- result:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			event.Meta.Pending.Done()
+		}
+	}
+}
@@ -38,6 +38,41 @@ func LoadMaskConfig(path string) (common.MaskConfig, error) {
 	return config, err
 }
 
+func LoadParseConfig(path string) (common.ParseConfig, error) {
+	fmt.Println("Loading parse config:", path)
+	file, err := os.Open(path)
+	if err != nil {
+		return common.ParseConfig{}, fmt.Errorf("error reading YAML file: %w", err)
+	}
+	defer file.Close()
+
+	var config common.ParseConfig
+	decoder := yaml.NewDecoder(file)
+	if err = decoder.Decode(&config); err != nil {
+		return common.ParseConfig{}, err
+	}
+	return config, err
+}
+
+// LoadAPIKeys reads a key file, a YAML document with the same keys list as the auth config
+func LoadAPIKeys(path string) ([]common.APIKeyConfig, error) {
+	fmt.Println("Loading API keys:", path)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading YAML file: %w", err)
+	}
+	defer file.Close()
+
+	var config struct {
+		Keys []common.APIKeyConfig `yaml:"keys"`
+	}
+	decoder := yaml.NewDecoder(file)
+	if err = decoder.Decode(&config); err != nil {
+		return nil, err
+	}
+	return config.Keys, nil
+}
+
 func LoadCommonConfig() (common.Config, error) {
 	fmt.Println("Loading base config")
 	if ConfigPath == "" {
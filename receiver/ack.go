@@ -0,0 +1,51 @@
+package receiver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+const (
+	AckStatus  = "ack"
+	NackStatus = "nack"
+
+	// Nack reasons
+	ReasonDecodeError = "decode_error"
+	ReasonQueueFull   = "queue_full"
+	ReasonForbidden   = "forbidden"
+)
+
+// Ack is written back for every text frame on a connection in ack mode
+type Ack struct {
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ackRequested reports whether the client opened the connection with ?ack=true
+func ackRequested(req *http.Request) bool {
+	ack, err := strconv.ParseBool(req.URL.Query().Get("ack"))
+	return err == nil && ack
+}
+
+// messageID pulls the client supplied id from a message, even when the event itself is invalid
+func messageID(message []byte) string {
+	var envelope struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(message, &envelope); err != nil || len(envelope.ID) == 0 {
+		return ""
+	}
+	var id string
+	if err := json.Unmarshal(envelope.ID, &id); err == nil {
+		return id
+	}
+	// Numeric ids are echoed back as their JSON text
+	return string(envelope.ID)
+}
+
+func nack(id string, reason string, err error) Ack {
+	return Ack{ID: id, Status: NackStatus, Reason: reason, Error: err.Error()}
+}
@@ -0,0 +1,69 @@
+package receiver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialTestServer(t *testing.T, server *httptest.Server, path string) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + path
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", url, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestWebSocketAckMode(t *testing.T) {
+	r, eventChan := newTestReceiver(1)
+	server := httptest.NewServer(http.HandlerFunc(r.HandleWebSocket))
+	defer server.Close()
+	conn := dialTestServer(t, server, "/ws?ack=true")
+
+	tests := []struct {
+		name    string
+		message string
+		want    Ack
+	}{
+		{
+			name:    "accepted",
+			message: `{"id":"1","type":"a","payload":{}}`,
+			want:    Ack{ID: "1", Status: AckStatus},
+		},
+		{
+			name:    "queue full",
+			message: `{"id":"2","type":"a","payload":{}}`,
+			want:    Ack{ID: "2", Status: NackStatus, Reason: ReasonQueueFull},
+		},
+		{
+			name:    "decode error",
+			message: `{"id":3,"type":"a","payload":[]}`,
+			want:    Ack{ID: "3", Status: NackStatus, Reason: ReasonDecodeError},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(tt.message)); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			var ack Ack
+			if err := conn.ReadJSON(&ack); err != nil {
+				t.Fatalf("read ack: %v", err)
+			}
+			if ack.ID != tt.want.ID || ack.Status != tt.want.Status || ack.Reason != tt.want.Reason {
+				t.Errorf("ack = %+v, want %+v", ack, tt.want)
+			}
+		})
+	}
+
+	if len(eventChan) != 1 {
+		t.Errorf("queued events = %d, want 1", len(eventChan))
+	}
+}
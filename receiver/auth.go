@@ -0,0 +1,137 @@
+package receiver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"clutch/common"
+	"clutch/config"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	errMissingAPIKey = errors.New("missing API key")
+	errInvalidAPIKey = errors.New("invalid API key")
+	errForbiddenType = errors.New("API key may not publish this event type")
+	errChatType      = errors.New("chat questions are only accepted on /chat")
+)
+
+// apiKey is a configured key, looked up by the sha256 of what the client sends
+type apiKey struct {
+	name       string
+	eventTypes []string
+	rateLimit  *common.RateLimit
+	limiter    *rate.Limiter
+}
+
+// apiKeyContextKey stores the authenticated key on the request context
+type apiKeyContextKey struct{}
+
+// LoadAPIKeys reads the keys of the auth config and its key file. Authentication
+// stays off when neither holds a key.
+func (r *Receiver) LoadAPIKeys(cfg common.AuthConfig) error {
+	configs := cfg.Keys
+	if cfg.KeyFile != "" {
+		fileKeys, err := config.LoadAPIKeys(cfg.KeyFile)
+		if err != nil {
+			return err
+		}
+		configs = append(configs, fileKeys...)
+	}
+
+	keys := make(map[[sha256.Size]byte]*apiKey, len(configs))
+	for i, key := range configs {
+		decoded, err := hex.DecodeString(strings.TrimPrefix(key.Hash, "sha256:"))
+		if err != nil || len(decoded) != sha256.Size {
+			return fmt.Errorf("API key %d (%s): hash is not a hex encoded sha256", i, key.Name)
+		}
+		for _, pattern := range key.EventTypes {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("API key %d (%s): invalid event type pattern %q", i, key.Name, pattern)
+			}
+		}
+		keys[[sha256.Size]byte(decoded)] = &apiKey{name: key.Name, eventTypes: key.EventTypes, rateLimit: key.RateLimit}
+	}
+	if len(keys) > 0 {
+		r.apiKeys = keys
+		fmt.Println("Loaded API keys:", len(keys))
+	}
+	return nil
+}
+
+// presentedKey reads the key from the Authorization bearer token, the X-API-Key header
+// or the api_key query parameter, which browsers need since they cannot set WebSocket headers
+func presentedKey(req *http.Request) string {
+	if token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if key := req.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return req.URL.Query().Get("api_key")
+}
+
+func (r *Receiver) authenticate(req *http.Request) (*apiKey, error) {
+	presented := presentedKey(req)
+	if presented == "" {
+		return nil, errMissingAPIKey
+	}
+	key, ok := r.apiKeys[sha256.Sum256([]byte(presented))]
+	if !ok {
+		return nil, errInvalidAPIKey
+	}
+	return key, nil
+}
+
+// requireAPIKey rejects requests without a valid key before the handler, and so
+// before any WebSocket upgrade, runs
+func (r *Receiver) requireAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if r.apiKeys == nil {
+			next(w, req)
+			return
+		}
+		key, err := r.authenticate(req)
+		if err != nil {
+			fmt.Printf("Rejected request to %s from %s: %v\n", req.URL.Path, req.RemoteAddr, err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="clutch"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next(w, req.WithContext(context.WithValue(req.Context(), apiKeyContextKey{}, key)))
+	}
+}
+
+func (k *apiKey) allows(eventType string) bool {
+	if len(k.eventTypes) == 0 {
+		return true
+	}
+	for _, pattern := range k.eventTypes {
+		if matched, _ := path.Match(pattern, eventType); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize checks that the key the request was authenticated with may publish the event type.
+// Requests without a key, because authentication is off, may publish anything but chat
+// questions: those are answered on the session they name, so only /chat creates them.
+func authorize(ctx context.Context, eventType string) error {
+	if eventType == common.ChatEventType {
+		return errChatType
+	}
+	key, ok := ctx.Value(apiKeyContextKey{}).(*apiKey)
+	if !ok || key.allows(eventType) {
+		return nil
+	}
+	fmt.Printf("Rejected %q event from API key %q: not in its event types\n", eventType, key.name)
+	return fmt.Errorf("%w: %q", errForbiddenType, eventType)
+}
@@ -0,0 +1,166 @@
+package receiver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"clutch/common"
+
+	"github.com/gorilla/websocket"
+)
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func newAuthReceiver(t *testing.T, size int) (*Receiver, chan common.Event) {
+	t.Helper()
+	r, eventChan := newTestReceiver(size)
+	keyFile := filepath.Join(t.TempDir(), "keys.yaml")
+	if err := os.WriteFile(keyFile, []byte("keys:\n  - name: ops\n    hash: \""+hashKey("ops-key")+"\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	err := r.LoadAPIKeys(common.AuthConfig{
+		Keys:    []common.APIKeyConfig{{Name: "sensors", Hash: "sha256:" + hashKey("sensor-key"), EventTypes: []string{"sensor_*"}}},
+		KeyFile: keyFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, eventChan
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	r, _ := newAuthReceiver(t, 10)
+	handler := r.requireAPIKey(r.HandleIngest)
+	body := `{"type":"sensor_readings","payload":{}}`
+
+	tests := []struct {
+		name   string
+		target string
+		header http.Header
+		want   int
+	}{
+		{"missing", "/events", nil, http.StatusUnauthorized},
+		{"wrong key", "/events", http.Header{"Authorization": {"Bearer nope"}}, http.StatusUnauthorized},
+		{"bearer", "/events", http.Header{"Authorization": {"Bearer sensor-key"}}, http.StatusAccepted},
+		{"header", "/events", http.Header{"X-Api-Key": {"ops-key"}}, http.StatusAccepted},
+		{"query", "/events?api_key=sensor-key", nil, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(body))
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %v, want %v: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("missing WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestAuthorizeEventTypes(t *testing.T) {
+	r, eventChan := newAuthReceiver(t, 10)
+	handler := r.requireAPIKey(r.HandleIngest)
+
+	req := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(`[{"type":"sensor_readings","payload":{}},{"type":"billing","payload":{}}]`))
+	req.Header.Set("Authorization", "Bearer sensor-key")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("status = %v, want %v", rec.Code, http.StatusMultiStatus)
+	}
+	if !strings.Contains(rec.Body.String(), `"status":403`) {
+		t.Errorf("response = %s, want a 403 result", rec.Body.String())
+	}
+	if len(eventChan) != 1 {
+		t.Errorf("queued events = %d, want 1", len(eventChan))
+	}
+
+	// Bulk lines are rejected one by one
+	req = httptest.NewRequest(http.MethodPost, "/events/bulk", strings.NewReader("{\"type\":\"billing\",\"payload\":{}}\n{\"type\":\"sensor_x\",\"payload\":{}}\n"))
+	req.Header.Set("X-API-Key", "sensor-key")
+	rec = httptest.NewRecorder()
+	r.requireAPIKey(r.HandleBulk)(rec, req)
+	if !strings.Contains(rec.Body.String(), `"accepted":1,"rejected":1`) {
+		t.Errorf("bulk summary = %s", rec.Body.String())
+	}
+}
+
+func TestWebSocketAPIKey(t *testing.T) {
+	r, eventChan := newAuthReceiver(t, 10)
+	server := httptest.NewServer(r.requireAPIKey(r.HandleWebSocket))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?ack=true"
+
+	if _, resp, err := websocket.DefaultDialer.Dial(url, nil); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("dial without a key: err = %v", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer sensor-key"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, frame := range []string{`{"id":"1","type":"billing","payload":{}}`, `{"id":"2","type":"sensor_readings","payload":{}}`} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var ack Ack
+	if err := conn.ReadJSON(&ack); err != nil {
+		t.Fatal(err)
+	}
+	if ack.Status != NackStatus || ack.Reason != ReasonForbidden {
+		t.Errorf("ack = %+v, want a forbidden nack", ack)
+	}
+	if err := conn.ReadJSON(&ack); err != nil {
+		t.Fatal(err)
+	}
+	if ack.Status != AckStatus {
+		t.Errorf("ack = %+v", ack)
+	}
+	if event := <-eventChan; event.Type != "sensor_readings" {
+		t.Errorf("event = %+v", event)
+	}
+}
+
+func TestLoadAPIKeysErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  common.AuthConfig
+	}{
+		{"bad hash", common.AuthConfig{Keys: []common.APIKeyConfig{{Hash: "plaintext"}}}},
+		{"bad pattern", common.AuthConfig{Keys: []common.APIKeyConfig{{Hash: hashKey("k"), EventTypes: []string{"["}}}}},
+		{"missing file", common.AuthConfig{KeyFile: "does/not/exist.yaml"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := newTestReceiver(1)
+			if err := r.LoadAPIKeys(tt.cfg); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+
+	// No keys leaves authentication off
+	r, _ := newTestReceiver(1)
+	if err := r.LoadAPIKeys(common.AuthConfig{}); err != nil || r.apiKeys != nil {
+		t.Errorf("apiKeys = %v, err = %v", r.apiKeys, err)
+	}
+}
@@ -0,0 +1,159 @@
+package receiver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// Lines longer than this are rejected instead of being buffered
+	maxBulkLineSize = 1 << 20
+	// Only the first errors are reported back, the counts stay exact
+	maxBulkErrors = 1000
+)
+
+var errLineTooLong = fmt.Errorf("line exceeds %d bytes", maxBulkLineSize)
+
+// BulkError reports a rejected NDJSON line
+type BulkError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// BulkSummary is returned after an NDJSON body or frame has been consumed
+type BulkSummary struct {
+	Lines    int `json:"lines"`
+	Accepted int `json:"accepted"`
+	Rejected int `json:"rejected"`
+	// Rejected lines that were over the rate limit and can be resent
+	Throttled int         `json:"throttled,omitempty"`
+	Errors    []BulkError `json:"errors"`
+	Truncated bool        `json:"errors_truncated,omitempty"`
+
+	retryAfter time.Duration
+}
+
+func (s *BulkSummary) reject(line int, err error) {
+	s.Rejected++
+	if wait, ok := retryAfter(err); ok {
+		s.Throttled++
+		s.retryAfter = max(s.retryAfter, wait)
+	}
+	if len(s.Errors) >= maxBulkErrors {
+		s.Truncated = true
+		return
+	}
+	s.Errors = append(s.Errors, BulkError{Line: line, Error: err.Error()})
+}
+
+// readLine reads the next newline terminated line, discarding the rest of lines over the limit
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if !tooLong {
+			if len(line)+len(chunk) > maxBulkLineSize {
+				tooLong = true
+				line = nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if tooLong && (err == nil || err == io.EOF) {
+			return nil, errLineTooLong
+		}
+		return line, err
+	}
+}
+
+// ingestNDJSON pushes every line of the reader as an event. Unlike single event
+// ingest it waits for room on the event channel so large backfills are throttled
+// instead of rejected.
+func (r *Receiver) ingestNDJSON(ctx context.Context, body io.Reader) (BulkSummary, error) {
+	summary := BulkSummary{Errors: []BulkError{}}
+	reader := bufio.NewReaderSize(body, 64*1024)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := readLine(reader)
+		if err == errLineTooLong {
+			summary.Lines++
+			summary.reject(lineNumber, err)
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return summary, err
+		}
+
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			summary.Lines++
+			event, decodeErr := decodeIngestEvent(trimmed)
+			if decodeErr == nil {
+				decodeErr = authorize(ctx, event.Type)
+			}
+			if decodeErr == nil {
+				decodeErr = limit(ctx, 1)
+			}
+			if decodeErr != nil {
+				summary.reject(lineNumber, decodeErr)
+			} else if sendErr := r.send(ctx, event); ctx.Err() != nil {
+				return summary, ctx.Err()
+			} else if sendErr != nil {
+				summary.reject(lineNumber, sendErr)
+			} else {
+				summary.Accepted++
+			}
+		}
+
+		if err == io.EOF {
+			return summary, nil
+		}
+	}
+}
+
+func (r *Receiver) HandleBulk(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	summary, err := r.ingestNDJSON(req.Context(), req.Body)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fmt.Printf("Error reading bulk body after %d lines (HandleBulk): %v\n", summary.Lines, err)
+		writeJSON(w, http.StatusBadRequest, summary)
+		return
+	}
+	fmt.Printf("Bulk ingest done (HandleBulk): %d accepted, %d rejected\n", summary.Accepted, summary.Rejected)
+	writeJSON(w, summary.status(w), summary)
+}
+
+// status is 429 when every line was over the rate limit. Partly throttled bodies
+// still get 200, with Retry-After set, so clients only resend the listed lines.
+func (s *BulkSummary) status(w http.ResponseWriter) int {
+	if s.Throttled == 0 {
+		return http.StatusOK
+	}
+	setRetryAfter(w, s.retryAfter)
+	if s.Accepted == 0 {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusOK
+}
+
+// bulkFromFrame consumes a binary WebSocket frame as NDJSON
+func (r *Receiver) bulkFromFrame(ctx context.Context, frame io.Reader) BulkSummary {
+	summary, err := r.ingestNDJSON(ctx, frame)
+	if err != nil {
+		fmt.Printf("Error reading bulk frame after %d lines (HandleWebSocket): %v\n", summary.Lines, err)
+	}
+	return summary
+}
@@ -0,0 +1,69 @@
+package receiver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIngestNDJSON(t *testing.T) {
+	r, eventChan := newTestReceiver(10)
+	body := strings.Join([]string{
+		`{"type":"a","payload":{"x":1}}`,
+		``,
+		`{"type":"a","payload":`,
+		`{"type":"b","payload":{"x":2}}`,
+		`{"payload":{"x":3}}`,
+	}, "\n")
+
+	summary, err := r.ingestNDJSON(context.Background(), strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("ingestNDJSON() error = %v", err)
+	}
+	if summary.Lines != 4 || summary.Accepted != 2 || summary.Rejected != 2 {
+		t.Errorf("summary = %+v, want 4 lines, 2 accepted, 2 rejected", summary)
+	}
+	if len(summary.Errors) != 2 || summary.Errors[0].Line != 3 || summary.Errors[1].Line != 5 {
+		t.Errorf("errors = %+v, want lines 3 and 5", summary.Errors)
+	}
+	if len(eventChan) != 2 {
+		t.Errorf("queued events = %d, want 2", len(eventChan))
+	}
+}
+
+func TestIngestNDJSONLineTooLong(t *testing.T) {
+	r, eventChan := newTestReceiver(10)
+	body := `{"type":"a","payload":{"x":"` + strings.Repeat("x", maxBulkLineSize) + `"}}` + "\n" + `{"type":"b","payload":{}}`
+
+	summary, err := r.ingestNDJSON(context.Background(), strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("ingestNDJSON() error = %v", err)
+	}
+	if summary.Rejected != 1 || summary.Errors[0].Line != 1 {
+		t.Errorf("summary = %+v, want line 1 rejected", summary)
+	}
+	if event := <-eventChan; event.Type != "b" {
+		t.Errorf("event type = %v, want %v", event.Type, "b")
+	}
+}
+
+func TestHandleBulk(t *testing.T) {
+	r, _ := newTestReceiver(10)
+	req := httptest.NewRequest(http.MethodPost, "/events/bulk", strings.NewReader("{\"type\":\"a\",\"payload\":{}}\nnope\n"))
+	rec := httptest.NewRecorder()
+	r.HandleBulk(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v", rec.Code, http.StatusOK)
+	}
+	var summary BulkSummary
+	if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("error decoding summary: %v", err)
+	}
+	if summary.Accepted != 1 || summary.Rejected != 1 || summary.Errors[0].Line != 2 {
+		t.Errorf("summary = %+v, want line 2 rejected", summary)
+	}
+}
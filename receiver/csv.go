@@ -0,0 +1,357 @@
+package receiver
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"clutch/common"
+)
+
+const (
+	defaultCSVType = "csv"
+	// Column types are inferred from the rows following the header
+	csvSampleRows = 100
+)
+
+// Column kinds, values that do not fit their column's kind are kept as strings
+const (
+	stringColumn    = "string"
+	numberColumn    = "number"
+	boolColumn      = "bool"
+	timestampColumn = "timestamp"
+)
+
+var (
+	errEmptyCSV = errors.New("no header row")
+
+	// Timestamp layouts tried in order, a column keeps the first one all of its samples parse with
+	csvTimeLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05",
+		"2006-01-02",
+		"01/02/2006 15:04:05",
+		"01/02/2006 15:04",
+		"01/02/2006",
+	}
+)
+
+// csvColumn is a header field with the kind inferred for its values
+type csvColumn struct {
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	Layout string `json:"layout,omitempty"`
+}
+
+// csvRow is a record read ahead while sampling
+type csvRow struct {
+	record []string
+	number int
+	offset int64
+	err    error
+}
+
+// csvStream turns the rows of a delimited body into payloads. Without a known
+// set of columns it reads the header and a sample of rows to infer them first.
+type csvStream struct {
+	reader  *csv.Reader
+	columns []csvColumn
+	paths   map[string]string
+	pending []csvRow
+	number  int
+	offset  int64
+}
+
+func newCSVStream(src io.Reader, delimiter rune, paths map[string]string, columns []csvColumn) (*csvStream, error) {
+	reader := csv.NewReader(src)
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	s := &csvStream{reader: reader, columns: columns, paths: paths}
+	if columns != nil {
+		return s, nil
+	}
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errEmptyCSV
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading header: %w", err)
+	}
+	s.number = 1
+	s.offset = reader.InputOffset()
+
+	var sample [][]string
+	for len(s.pending) < csvSampleRows {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		s.number++
+		row := csvRow{record: record, number: s.number, offset: reader.InputOffset(), err: err}
+		if err == nil {
+			sample = append(sample, record)
+		}
+		s.pending = append(s.pending, row)
+	}
+	s.columns = inferColumns(header, sample)
+	return s, nil
+}
+
+// Next returns the payload and record number of the next row. A row error does
+// not stop the stream, io.EOF does.
+func (s *csvStream) Next() (common.M, int, error) {
+	var row csvRow
+	if len(s.pending) > 0 {
+		row, s.pending = s.pending[0], s.pending[1:]
+	} else {
+		record, err := s.reader.Read()
+		if err == io.EOF {
+			return nil, s.number, io.EOF
+		}
+		s.number++
+		row = csvRow{record: record, number: s.number, offset: s.reader.InputOffset(), err: err}
+	}
+	s.offset = row.offset
+
+	if row.err != nil {
+		return nil, row.number, row.err
+	}
+	if len(row.record) != len(s.columns) {
+		return nil, row.number, fmt.Errorf("row has %d fields, the header has %d", len(row.record), len(s.columns))
+	}
+	return s.payload(row.record), row.number, nil
+}
+
+// Offset is the position in the source right after the last row returned by Next
+func (s *csvStream) Offset() int64 {
+	return s.offset
+}
+
+func (s *csvStream) payload(record []string) common.M {
+	payload := common.M{}
+	for i, column := range s.columns {
+		value := strings.TrimSpace(record[i])
+		if value == "" {
+			continue
+		}
+		path := column.Name
+		if mapped, ok := s.paths[column.Name]; ok {
+			path = mapped
+		}
+		setPath(payload, path, column.convert(value))
+	}
+	return payload
+}
+
+// setPath sets a dotted path, creating the nested objects on the way
+func setPath(payload common.M, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	current := map[string]interface{}(payload)
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := current[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			current[key] = next
+		}
+		current = next
+	}
+	current[keys[len(keys)-1]] = value
+}
+
+func (c csvColumn) convert(value string) interface{} {
+	switch c.Kind {
+	case numberColumn:
+		if isNumber(value) {
+			return json.Number(value)
+		}
+	case boolColumn:
+		if b, ok := parseBool(value); ok {
+			return b
+		}
+	case timestampColumn:
+		if t, err := time.Parse(c.Layout, value); err == nil {
+			return t.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	return value
+}
+
+// isNumber only accepts valid JSON numbers so values like "007" stay strings
+func isNumber(value string) bool {
+	if value == "" || (value[0] != '-' && (value[0] < '0' || value[0] > '9')) {
+		return false
+	}
+	return json.Valid([]byte(value))
+}
+
+func parseBool(value string) (bool, bool) {
+	switch strings.ToLower(value) {
+	case "true":
+		return true, true
+	case "false":
+		return false, true
+	}
+	return false, false
+}
+
+// inferColumns picks the narrowest kind every non empty sample value of a column fits
+func inferColumns(header []string, sample [][]string) []csvColumn {
+	columns := make([]csvColumn, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		if name == "" {
+			name = fmt.Sprintf("column_%d", i+1)
+		}
+
+		var values []string
+		for _, record := range sample {
+			if i < len(record) {
+				if value := strings.TrimSpace(record[i]); value != "" {
+					values = append(values, value)
+				}
+			}
+		}
+		columns[i] = csvColumn{Name: name, Kind: stringColumn}
+		if len(values) == 0 {
+			continue
+		}
+		switch {
+		case all(values, isNumber):
+			columns[i].Kind = numberColumn
+		case all(values, func(v string) bool { _, ok := parseBool(v); return ok }):
+			columns[i].Kind = boolColumn
+		default:
+			for _, layout := range csvTimeLayouts {
+				if all(values, func(v string) bool { _, err := time.Parse(layout, v); return err == nil }) {
+					columns[i].Kind = timestampColumn
+					columns[i].Layout = layout
+					break
+				}
+			}
+		}
+	}
+	return columns
+}
+
+func all(values []string, fits func(string) bool) bool {
+	for _, value := range values {
+		if !fits(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// csvDelimiter reads a delimiter setting, "tab" and "\t" both mean a tab
+func csvDelimiter(value string) (rune, error) {
+	switch value {
+	case "", ",":
+		return ',', nil
+	case "tab", "\\t", "\t":
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(value)
+	if size != len(value) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+		return 0, fmt.Errorf("invalid delimiter %q", value)
+	}
+	return r, nil
+}
+
+// csvConfigFor returns the settings configured for an event type, if any
+func csvConfigFor(eventType string) common.CSVConfig {
+	for _, cfg := range common.GetConfig().Inputs.CSV {
+		if cfg.Type == eventType {
+			return cfg
+		}
+	}
+	return common.CSVConfig{Type: eventType}
+}
+
+// ingestCSV pushes every row as an event, waiting for room on the event channel like NDJSON bulk ingest
+func (r *Receiver) ingestCSV(ctx context.Context, body io.Reader, cfg common.CSVConfig) (BulkSummary, error) {
+	summary := BulkSummary{Errors: []BulkError{}}
+	delimiter, err := csvDelimiter(cfg.Delimiter)
+	if err != nil {
+		return summary, err
+	}
+	stream, err := newCSVStream(body, delimiter, cfg.Columns, nil)
+	if err != nil {
+		return summary, err
+	}
+	for {
+		payload, number, err := stream.Next()
+		if err == io.EOF {
+			return summary, nil
+		}
+		summary.Lines++
+		if err == nil {
+			err = limit(ctx, 1)
+		}
+		if err != nil {
+			summary.reject(number, err)
+			continue
+		}
+		if err := r.send(ctx, common.Event{Type: cfg.Type, Payload: payload}); ctx.Err() != nil {
+			return summary, ctx.Err()
+		} else if err != nil {
+			summary.reject(number, err)
+		} else {
+			summary.Accepted++
+		}
+	}
+}
+
+// HandleCSV accepts a CSV or TSV upload on POST /events/csv?type=<type>. The
+// delimiter comes from the delimiter parameter, a text/tab-separated-values
+// content type or the csv input configured for the type.
+func (r *Receiver) HandleCSV(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := req.URL.Query()
+	eventType := query.Get("type")
+	if eventType == "" {
+		http.Error(w, errMissingType.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := authorize(req.Context(), eventType); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	cfg := csvConfigFor(eventType)
+	if contentType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); contentType == "text/tab-separated-values" {
+		cfg.Delimiter = "tab"
+	}
+	if query.Has("delimiter") {
+		cfg.Delimiter = query.Get("delimiter")
+	}
+
+	body, err := decompress(req, req.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error reading body: %v", err), http.StatusBadRequest)
+		return
+	}
+	summary, err := r.ingestCSV(req.Context(), body, cfg)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fmt.Printf("Error reading CSV body after %d rows (HandleCSV): %v\n", summary.Lines, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Printf("CSV ingest done (HandleCSV): %d accepted, %d rejected\n", summary.Accepted, summary.Rejected)
+	writeJSON(w, summary.status(w), summary)
+}
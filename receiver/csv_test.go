@@ -0,0 +1,183 @@
+package receiver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"clutch/common"
+)
+
+func TestInferColumns(t *testing.T) {
+	header := []string{"\ufefffield", "yield", "organic", "harvested_at", "lot", "note", ""}
+	sample := [][]string{
+		{"north", "12.5", "true", "2024-09-01 08:30:00", "007", "", "x"},
+		{"south", "-3", "FALSE", "2024-09-02 17:00:00", "12", "", "y"},
+	}
+	want := []csvColumn{
+		{Name: "field", Kind: stringColumn},
+		{Name: "yield", Kind: numberColumn},
+		{Name: "organic", Kind: boolColumn},
+		{Name: "harvested_at", Kind: timestampColumn, Layout: "2006-01-02 15:04:05"},
+		{Name: "lot", Kind: stringColumn},
+		{Name: "note", Kind: stringColumn},
+		{Name: "column_7", Kind: stringColumn},
+	}
+	got := inferColumns(header, sample)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("column %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestCSVDelimiter(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    rune
+		wantErr bool
+	}{
+		{"", ',', false},
+		{"tab", '\t', false},
+		{`\t`, '\t', false},
+		{";", ';', false},
+		{"|", '|', false},
+		{`"`, 0, true},
+		{"ab", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := csvDelimiter(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("csvDelimiter(%q) = %q, %v, want %q", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestHandleCSV(t *testing.T) {
+	cfg := common.GetConfig()
+	defer common.SetConfig(cfg)
+	testCfg := cfg
+	testCfg.Inputs.CSV = []common.CSVConfig{{
+		Type:    "harvest",
+		Columns: map[string]string{"yield": "harvest.yield_kg", "field": "location.field"},
+	}}
+	common.SetConfig(testCfg)
+
+	r, eventChan := newTestReceiver(10)
+	body := "field\tyield\tharvested_at\n" +
+		"north\t1200\t2024-09-01\n" +
+		"south\t\t2024-09-02\n" +
+		"east\t900\n"
+	req := httptest.NewRequest(http.MethodPost, "/events/csv?type=harvest", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/tab-separated-values")
+	rec := httptest.NewRecorder()
+	r.HandleCSV(rec, req)
+
+	var summary BulkSummary
+	if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("error decoding response %q: %v", rec.Body.String(), err)
+	}
+	if rec.Code != http.StatusOK || summary.Accepted != 2 || summary.Rejected != 1 {
+		t.Fatalf("status = %d, summary = %+v", rec.Code, summary)
+	}
+	if summary.Errors[0].Line != 4 {
+		t.Errorf("rejected line = %d, want 4", summary.Errors[0].Line)
+	}
+
+	first := <-eventChan
+	if first.Type != "harvest" {
+		t.Errorf("type = %q, want harvest", first.Type)
+	}
+	harvest, _ := first.Payload["harvest"].(map[string]interface{})
+	location, _ := first.Payload["location"].(map[string]interface{})
+	if harvest["yield_kg"] != json.Number("1200") || location["field"] != "north" {
+		t.Errorf("payload = %v", first.Payload)
+	}
+	if first.Payload["harvested_at"] != "2024-09-01T00:00:00Z" {
+		t.Errorf("harvested_at = %v", first.Payload["harvested_at"])
+	}
+	second := <-eventChan
+	if _, ok := second.Payload["harvest"]; ok {
+		t.Errorf("empty cell was kept: %v", second.Payload)
+	}
+}
+
+func TestHandleCSVErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		body   string
+	}{
+		{"missing type", "/events/csv", "a,b\n1,2\n"},
+		{"empty body", "/events/csv?type=harvest", ""},
+		{"bad delimiter", "/events/csv?type=harvest&delimiter=ab", "a,b\n1,2\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := newTestReceiver(10)
+			rec := httptest.NewRecorder()
+			r.HandleCSV(rec, httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body)))
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestCSVFileInput(t *testing.T) {
+	dir := t.TempDir()
+	eventChan := make(chan common.Event, 100)
+	newInput := func() *CSVFileInput {
+		input := NewCSVFileInput(&common.CSVConfig{
+			Paths:      []string{filepath.Join(dir, "*.csv")},
+			Type:       "harvest",
+			Checkpoint: filepath.Join(dir, "checkpoint.json"),
+		})
+		input.eventChan = &eventChan
+		if err := input.loadCheckpoints(); err != nil {
+			t.Fatalf("loadCheckpoints() error = %v", err)
+		}
+		return input
+	}
+
+	path := filepath.Join(dir, "day1.csv")
+	appendFile(t, path, "machine,yield\nh1,10\nh2,20\n")
+	input := newInput()
+	input.poll()
+	if got := len(drain(eventChan)); got != 0 {
+		t.Fatalf("read %d events before the file was stable", got)
+	}
+	input.poll()
+	if got := drain(eventChan); len(got) != 2 || got[1].Payload["yield"] != json.Number("20") {
+		t.Fatalf("events = %+v", got)
+	}
+	input.poll()
+	if got := len(drain(eventChan)); got != 0 {
+		t.Fatalf("file was read twice, %d more events", got)
+	}
+
+	// Rows appended later keep the columns inferred from the header
+	appendFile(t, path, "h3,lots\n")
+	input = newInput()
+	input.poll()
+	input.poll()
+	got := drain(eventChan)
+	if len(got) != 1 || got[0].Payload["machine"] != "h3" || got[0].Payload["yield"] != "lots" {
+		t.Fatalf("events after append = %+v", got)
+	}
+
+	// A replaced file is read from its header again
+	if err := os.WriteFile(path, []byte("machine,status\nh9,idle\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	input.poll()
+	input.poll()
+	got = drain(eventChan)
+	if len(got) != 1 || got[0].Payload["status"] != "idle" {
+		t.Fatalf("events after replace = %+v", got)
+	}
+}
@@ -0,0 +1,230 @@
+package receiver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"clutch/common"
+)
+
+// csvCheckpoint remembers how far a file was read and the columns inferred from
+// its header, so rows appended later are read the same way
+type csvCheckpoint struct {
+	tailCheckpoint
+	Columns []csvColumn `json:"columns"`
+}
+
+// CSVFileInput reads the delimited files dropped in the directories matching a
+// set of globs. A file is read once its size stopped changing between two polls.
+type CSVFileInput struct {
+	cfg         *common.CSVConfig
+	eventChan   *chan common.Event
+	sizes       map[string]int64
+	checkpoints map[string]csvCheckpoint
+	done        chan struct{}
+	wg          sync.WaitGroup
+	eventLog
+}
+
+func NewCSVFileInput(cfg *common.CSVConfig) *CSVFileInput {
+	return &CSVFileInput{
+		cfg:         cfg,
+		eventChan:   &common.EventChan,
+		sizes:       make(map[string]int64),
+		checkpoints: make(map[string]csvCheckpoint),
+		done:        make(chan struct{}),
+	}
+}
+
+func (c *CSVFileInput) eventType() string {
+	if c.cfg.Type != "" {
+		return c.cfg.Type
+	}
+	return defaultCSVType
+}
+
+func (c *CSVFileInput) checkpointPath() string {
+	if c.cfg.Checkpoint != "" {
+		return c.cfg.Checkpoint
+	}
+	return fmt.Sprintf(".clutch_csv_%s.json", c.eventType())
+}
+
+// Start loads the checkpoint file and polls the globs until Close is called
+func (c *CSVFileInput) Start() error {
+	if _, err := csvDelimiter(c.cfg.Delimiter); err != nil {
+		return err
+	}
+	if err := c.loadCheckpoints(); err != nil {
+		return err
+	}
+	interval := c.cfg.PollInterval
+	if interval <= 0 {
+		interval = defaultTailPollInterval
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			c.poll()
+			select {
+			case <-ticker.C:
+			case <-c.done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops polling and writes the final checkpoint
+func (c *CSVFileInput) Close() error {
+	close(c.done)
+	c.wg.Wait()
+	return c.saveCheckpoints()
+}
+
+func (c *CSVFileInput) loadCheckpoints() error {
+	data, err := os.ReadFile(c.checkpointPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading csv checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, &c.checkpoints); err != nil {
+		return fmt.Errorf("error decoding csv checkpoint: %w", err)
+	}
+	return nil
+}
+
+// saveCheckpoints writes the checkpoint file atomically
+func (c *CSVFileInput) saveCheckpoints() error {
+	data, err := json.MarshalIndent(c.checkpoints, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := c.checkpointPath()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("error writing csv checkpoint: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// poll reads the files that stopped growing and forgets the ones that were removed
+func (c *CSVFileInput) poll() {
+	present := make(map[string]bool)
+	for _, pattern := range c.cfg.Paths {
+		matched, err := filepath.Glob(pattern)
+		if err != nil {
+			fmt.Println("Error matching csv path:", err)
+			continue
+		}
+		for _, path := range matched {
+			info, err := os.Stat(path)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			present[path] = true
+
+			previous, seen := c.sizes[path]
+			c.sizes[path] = info.Size()
+			if !seen || previous != info.Size() {
+				continue
+			}
+			if err := c.read(path); err != nil {
+				fmt.Printf("Error reading csv file %s: %v\n", path, err)
+			}
+		}
+	}
+
+	for path := range c.sizes {
+		if !present[path] {
+			delete(c.sizes, path)
+			delete(c.checkpoints, path)
+		}
+	}
+	if err := c.saveCheckpoints(); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// read emits the rows added since the checkpoint, all of them for a new or replaced file
+func (c *CSVFileInput) read(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	cp, ok := c.checkpoints[path]
+	if ok && (cp.Offset > info.Size() || !matches(file, cp.tailCheckpoint)) {
+		fmt.Printf("CSV file %s was replaced, reading it from the start\n", path)
+		ok = false
+	}
+	if !ok {
+		cp = csvCheckpoint{}
+	}
+	if cp.Offset == info.Size() {
+		return nil
+	}
+	if _, err := file.Seek(cp.Offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	delimiter, _ := csvDelimiter(c.cfg.Delimiter)
+	stream, err := newCSVStream(file, delimiter, c.cfg.Columns, cp.Columns)
+	if err != nil {
+		return err
+	}
+	start, committed := cp.Offset, stream.Offset()
+	rows, rejected := 0, 0
+	defer func() {
+		// Keep the progress made even when Close interrupted the file
+		cp.Offset = start + committed
+		cp.Columns = stream.columns
+		cp.Fingerprint, cp.FingerprintSize, _ = fingerprint(file, fingerprintSize)
+		c.checkpoints[path] = cp
+		fmt.Printf("CSV file %s read: %d rows, %d rejected\n", path, rows, rejected)
+	}()
+
+	for {
+		payload, number, err := stream.Next()
+		if err == io.EOF {
+			return nil
+		}
+		rows++
+		if err != nil {
+			rejected++
+			fmt.Printf("Rejected csv row %d of %s: %v\n", number, path, err)
+			committed = stream.Offset()
+			continue
+		}
+		event := common.Event{Type: c.eventType(), Payload: payload, Meta: common.NewEventMeta("csv", "")}
+		// Logged before the checkpoint moves past the row
+		if err := c.logEvent(&event); err != nil {
+			fmt.Printf("Error logging csv row %d of %s: %v\n", number, path, err)
+		}
+		select {
+		case *c.eventChan <- event:
+			committed = stream.Offset()
+		case <-c.done:
+			// The row is read again on the next start
+			c.discard(event)
+			return nil
+		}
+	}
+}
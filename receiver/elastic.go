@@ -0,0 +1,228 @@
+package receiver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"clutch/common"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Version reported to Elasticsearch clients, they refuse to talk to servers that are too old
+	elasticCompatVersion = "8.15.0"
+	// Elasticsearch rejects longer document ids
+	maxBulkIDSize = 512
+)
+
+// bulkItemError mirrors the error object of a failed _bulk item
+type bulkItemError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// bulkItemResult mirrors the per-action result of an Elasticsearch _bulk response
+type bulkItemResult struct {
+	Index   string         `json:"_index,omitempty"`
+	ID      string         `json:"_id,omitempty"`
+	Version int            `json:"_version,omitempty"`
+	Result  string         `json:"result,omitempty"`
+	Status  int            `json:"status"`
+	Error   *bulkItemError `json:"error,omitempty"`
+}
+
+// ElasticBulkResponse is shaped like the Elasticsearch _bulk response
+type ElasticBulkResponse struct {
+	Took   int64                       `json:"took"`
+	Errors bool                        `json:"errors"`
+	Items  []map[string]bulkItemResult `json:"items"`
+}
+
+// bulkAction is the metadata line that precedes each document
+type bulkAction struct {
+	Index string `json:"_index"`
+	ID    string `json:"_id"`
+}
+
+func (b *ElasticBulkResponse) add(action string, result bulkItemResult) {
+	if result.Error != nil {
+		b.Errors = true
+	}
+	b.Items = append(b.Items, map[string]bulkItemResult{action: result})
+}
+
+func bulkFailure(index string, id string, status int, errorType string, reason string) bulkItemResult {
+	return bulkItemResult{
+		Index:  index,
+		ID:     id,
+		Status: status,
+		Error:  &bulkItemError{Type: errorType, Reason: reason},
+	}
+}
+
+func writeElasticJSON(w http.ResponseWriter, status int, v interface{}) {
+	// Elasticsearch 8 clients check this header before reading any response
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	writeJSON(w, status, v)
+}
+
+// HandleElasticInfo answers GET / like an Elasticsearch node so Beats and Logstash accept the endpoint
+func (r *Receiver) HandleElasticInfo(w http.ResponseWriter, req *http.Request) {
+	writeElasticJSON(w, http.StatusOK, common.M{
+		"name":         "clutch",
+		"cluster_name": "clutch",
+		"version": common.M{
+			"number":                              elasticCompatVersion,
+			"build_flavor":                        "default",
+			"minimum_wire_compatibility_version":  "7.17.0",
+			"minimum_index_compatibility_version": "7.0.0",
+		},
+		"tagline": "You Know, for Search",
+	})
+}
+
+// HandleElasticBulk accepts the Elasticsearch _bulk format on /_bulk and /{index}/_bulk.
+// Indexed documents become events of their index type and go through the pipeline.
+func (r *Receiver) HandleElasticBulk(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost && req.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	start := time.Now()
+
+	body, err := decompress(req, req.Body)
+	if err != nil {
+		writeElasticJSON(w, http.StatusBadRequest, common.M{
+			"error":  bulkItemError{Type: "parse_exception", Reason: err.Error()},
+			"status": http.StatusBadRequest,
+		})
+		return
+	}
+
+	response, err := r.ingestElasticBulk(req.Context(), body, req.PathValue("index"))
+	if err != nil {
+		fmt.Println("Error reading _bulk body (HandleElasticBulk):", err)
+		writeElasticJSON(w, http.StatusBadRequest, common.M{
+			"error":  bulkItemError{Type: "parse_exception", Reason: err.Error()},
+			"status": http.StatusBadRequest,
+		})
+		return
+	}
+	response.Took = time.Since(start).Milliseconds()
+	fmt.Printf("Bulk request done (HandleElasticBulk): %d items, errors: %v\n", len(response.Items), response.Errors)
+	writeElasticJSON(w, http.StatusOK, response)
+}
+
+func (r *Receiver) ingestElasticBulk(ctx context.Context, body io.Reader, defaultIndex string) (ElasticBulkResponse, error) {
+	response := ElasticBulkResponse{Items: []map[string]bulkItemResult{}}
+	reader := bufio.NewReaderSize(body, 64*1024)
+	for {
+		line, err := readLine(reader)
+		if err != nil && err != io.EOF {
+			return response, err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			if err == io.EOF {
+				return response, nil
+			}
+			continue
+		}
+
+		var actions map[string]bulkAction
+		if decodeErr := json.Unmarshal(line, &actions); decodeErr != nil || len(actions) != 1 {
+			return response, fmt.Errorf("malformed action/metadata line: %s", line)
+		}
+		for action, meta := range actions {
+			if meta.Index == "" {
+				meta.Index = defaultIndex
+			}
+			switch action {
+			case "index", "create":
+				source, sourceErr := readLine(reader)
+				if sourceErr == errLineTooLong {
+					response.add(action, bulkFailure(meta.Index, meta.ID, http.StatusRequestEntityTooLarge,
+						"document_parsing_exception", sourceErr.Error()))
+					continue
+				}
+				if sourceErr != nil && sourceErr != io.EOF {
+					return response, sourceErr
+				}
+				response.add(action, r.indexBulkDocument(ctx, meta, source))
+				err = sourceErr
+			case "update":
+				// Skip the partial document, Clutch only appends events
+				if _, sourceErr := readLine(reader); sourceErr != nil && sourceErr != io.EOF {
+					return response, sourceErr
+				}
+				response.add(action, bulkFailure(meta.Index, meta.ID, http.StatusBadRequest,
+					"illegal_argument_exception", "update is not supported, events are append only"))
+			case "delete":
+				response.add(action, bulkFailure(meta.Index, meta.ID, http.StatusBadRequest,
+					"illegal_argument_exception", "delete is not supported, events are append only"))
+			default:
+				return response, fmt.Errorf("unknown bulk action %q", action)
+			}
+		}
+
+		if err == io.EOF {
+			return response, nil
+		}
+	}
+}
+
+func (r *Receiver) indexBulkDocument(ctx context.Context, meta bulkAction, source []byte) bulkItemResult {
+	if meta.ID == "" {
+		meta.ID = uuid.NewString()
+	}
+	if meta.Index == "" {
+		return bulkFailure(meta.Index, meta.ID, http.StatusBadRequest,
+			"action_request_validation_exception", "index is missing")
+	}
+	if len(meta.ID) > maxBulkIDSize {
+		return bulkFailure(meta.Index, meta.ID, http.StatusBadRequest, "action_request_validation_exception",
+			fmt.Sprintf("id is too long, must be no longer than %d bytes but was: %d", maxBulkIDSize, len(meta.ID)))
+	}
+
+	if err := authorize(ctx, meta.Index); err != nil {
+		return bulkFailure(meta.Index, meta.ID, http.StatusForbidden, "security_exception", err.Error())
+	}
+	if err := limit(ctx, 1); err != nil {
+		return bulkFailure(meta.Index, meta.ID, http.StatusTooManyRequests, "es_rejected_execution_exception", err.Error())
+	}
+
+	var payload common.M
+	decoder := json.NewDecoder(bytes.NewReader(source))
+	decoder.UseNumber() // This helps preserve number precision
+	if err := decoder.Decode(&payload); err != nil || payload == nil {
+		reason := "source is not a JSON object"
+		if err != nil {
+			reason = err.Error()
+		}
+		return bulkFailure(meta.Index, meta.ID, http.StatusBadRequest, "document_parsing_exception", reason)
+	}
+
+	// The document is stored under the id reported here, so a retried _id overwrites it
+	// instead of storing it twice. Clients retry items rejected with 429, just like a
+	// busy Elasticsearch node.
+	event := common.Event{Type: meta.Index, Payload: payload, Meta: common.EventMeta{ID: meta.ID}}
+	if err := r.enqueue(ctx, event); err != nil {
+		return bulkFailure(meta.Index, meta.ID, http.StatusTooManyRequests,
+			"es_rejected_execution_exception", err.Error())
+	}
+	return bulkItemResult{
+		Index:   meta.Index,
+		ID:      meta.ID,
+		Version: 1,
+		Result:  "created",
+		Status:  http.StatusCreated,
+	}
+}
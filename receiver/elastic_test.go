@@ -0,0 +1,106 @@
+package receiver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func postBulk(t *testing.T, r *Receiver, path string, body string) ElasticBulkResponse {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_bulk", r.HandleElasticBulk)
+	mux.HandleFunc("/{index}/_bulk", r.HandleElasticBulk)
+
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if rec.Header().Get("X-Elastic-Product") != "Elasticsearch" {
+		t.Errorf("missing X-Elastic-Product header")
+	}
+	var response ElasticBulkResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	return response
+}
+
+func TestHandleElasticBulk(t *testing.T) {
+	r, eventChan := newTestReceiver(10)
+	body := strings.Join([]string{
+		`{"index":{"_index":"clutch_testing_events","_id":"1"}}`,
+		`{"machine_id":"4","status":"running"}`,
+		`{"create":{}}`,
+		`{"machine_id":"5"}`,
+		`{"delete":{"_index":"clutch_testing_events","_id":"1"}}`,
+		`{"update":{"_index":"clutch_testing_events","_id":"1"}}`,
+		`{"doc":{"status":"idle"}}`,
+		`{"index":{"_index":"clutch_testing_events"}}`,
+		`not json`,
+	}, "\n") + "\n"
+
+	response := postBulk(t, r, "/machines/_bulk", body)
+	if !response.Errors || len(response.Items) != 5 {
+		t.Fatalf("response = %+v, want 5 items with errors", response)
+	}
+	want := []struct {
+		action string
+		status int
+		index  string
+	}{
+		{"index", 201, "clutch_testing_events"},
+		{"create", 201, "machines"},
+		{"delete", 400, "clutch_testing_events"},
+		{"update", 400, "clutch_testing_events"},
+		{"index", 400, "clutch_testing_events"},
+	}
+	for i, w := range want {
+		item, ok := response.Items[i][w.action]
+		if !ok || item.Status != w.status || item.Index != w.index {
+			t.Errorf("item %d = %+v, want %s %d on %s", i, response.Items[i], w.action, w.status, w.index)
+		}
+	}
+	if response.Items[0]["index"].ID != "1" || response.Items[1]["create"].ID == "" {
+		t.Errorf("ids = %q, %q", response.Items[0]["index"].ID, response.Items[1]["create"].ID)
+	}
+
+	first, second := <-eventChan, <-eventChan
+	if first.Type != "clutch_testing_events" || first.Payload["status"] != "running" || second.Type != "machines" {
+		t.Errorf("events = %+v, %+v", first, second)
+	}
+	// Documents are stored under the ids the client was told
+	if first.Meta.ID != "1" || second.Meta.ID != response.Items[1]["create"].ID {
+		t.Errorf("event ids = %q, %q", first.Meta.ID, second.Meta.ID)
+	}
+}
+
+func TestHandleElasticBulkLongID(t *testing.T) {
+	r, eventChan := newTestReceiver(1)
+	body := `{"index":{"_index":"a","_id":"` + strings.Repeat("x", maxBulkIDSize+1) + "\"}}\n{}\n"
+	response := postBulk(t, r, "/_bulk", body)
+	if item := response.Items[0]["index"]; item.Status != http.StatusBadRequest || len(eventChan) != 0 {
+		t.Errorf("item = %+v, want status 400", item)
+	}
+}
+
+func TestHandleElasticBulkQueueFull(t *testing.T) {
+	r, _ := newTestReceiver(1)
+	body := "{\"index\":{\"_index\":\"a\"}}\n{}\n{\"index\":{\"_index\":\"a\"}}\n{}\n"
+	response := postBulk(t, r, "/_bulk", body)
+	if item := response.Items[1]["index"]; item.Status != http.StatusTooManyRequests {
+		t.Errorf("item = %+v, want status 429", item)
+	}
+}
+
+func TestHandleElasticBulkMissingIndex(t *testing.T) {
+	r, _ := newTestReceiver(1)
+	response := postBulk(t, r, "/_bulk", "{\"index\":{}}\n{}")
+	if item := response.Items[0]["index"]; item.Status != http.StatusBadRequest {
+		t.Errorf("item = %+v, want status 400", item)
+	}
+}
@@ -0,0 +1,181 @@
+package receiver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+
+	"clutch/common"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// WebSocket subprotocols a client can ask for with Sec-WebSocket-Protocol.
+// Without one text frames are JSON events and binary frames NDJSON batches.
+const (
+	JSONSubprotocol     = "clutch.json"
+	MsgpackSubprotocol  = "clutch.msgpack"
+	ProtobufSubprotocol = "clutch.protobuf"
+)
+
+var wsSubprotocols = []string{MsgpackSubprotocol, ProtobufSubprotocol, JSONSubprotocol}
+
+// frameDecoder turns a single frame into an event and the id the client gave it
+type frameDecoder func(frame []byte) (common.Event, string, error)
+
+// binaryDecoder returns the decoder for binary frames of a negotiated subprotocol,
+// nil keeps binary frames as NDJSON batches
+func binaryDecoder(subprotocol string) frameDecoder {
+	switch subprotocol {
+	case MsgpackSubprotocol:
+		return decodeMsgpackEvent
+	case ProtobufSubprotocol:
+		return decodeProtobufEvent
+	}
+	return nil
+}
+
+func decodeJSONFrame(frame []byte) (common.Event, string, error) {
+	event, err := decodeEvent(frame)
+	return event, messageID(frame), err
+}
+
+// msgpackEvent is a MessagePack map with the same keys as a JSON event
+type msgpackEvent struct {
+	Type string `msgpack:"type"`
+	// Decoded by decodeMsgpackMap, so it is a map with string keys or nil
+	Payload interface{} `msgpack:"payload"`
+	ID      interface{} `msgpack:"id"`
+}
+
+func decodeMsgpackEvent(frame []byte) (common.Event, string, error) {
+	var decoded msgpackEvent
+	decoder := msgpack.NewDecoder(bytes.NewReader(frame))
+	decoder.UseLooseInterfaceDecoding(true)
+	decoder.SetMapDecoder(decodeMsgpackMap)
+	err := decoder.Decode(&decoded)
+
+	var id string
+	if decoded.ID != nil {
+		id = fmt.Sprint(decoded.ID)
+	}
+	if err != nil {
+		return common.Event{}, id, err
+	}
+	if decoded.Type == "" {
+		return common.Event{}, id, errMissingType
+	}
+	payload, ok := decoded.Payload.(map[string]interface{})
+	if !ok && decoded.Payload != nil {
+		return common.Event{}, id, fmt.Errorf("payload is a %T, not a map", decoded.Payload)
+	}
+	return common.Event{Type: decoded.Type, Payload: normalizeNumbers(payload).(map[string]interface{})}, id, nil
+}
+
+// decodeMsgpackMap decodes every MessagePack map as map[string]interface{}. Keys may
+// be of any type in MessagePack but only strings in JSON, so the others are
+// formatted (1 becomes "1") instead of failing the event.
+func decodeMsgpackMap(d *msgpack.Decoder) (interface{}, error) {
+	n, err := d.DecodeMapLen()
+	if err != nil || n == -1 {
+		return nil, err
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := d.DecodeInterfaceLoose()
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.DecodeInterfaceLoose()
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := key.(string); ok {
+			m[s] = value
+		} else {
+			m[fmt.Sprint(key)] = value
+		}
+	}
+	return m, nil
+}
+
+// decodeProtobufEvent decodes the Event message published in schemas/event.proto
+func decodeProtobufEvent(frame []byte) (common.Event, string, error) {
+	var event common.Event
+	var id string
+	payload := &structpb.Struct{}
+	for len(frame) > 0 {
+		number, wireType, n := protowire.ConsumeTag(frame)
+		if n < 0 {
+			return common.Event{}, id, protowire.ParseError(n)
+		}
+		frame = frame[n:]
+
+		if wireType != protowire.BytesType || number < 1 || number > 3 {
+			// Skip fields added by newer schemas
+			n = protowire.ConsumeFieldValue(number, wireType, frame)
+			if n < 0 {
+				return common.Event{}, id, protowire.ParseError(n)
+			}
+			frame = frame[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(frame)
+		if n < 0 {
+			return common.Event{}, id, protowire.ParseError(n)
+		}
+		frame = frame[n:]
+
+		switch number {
+		case 1:
+			event.Type = string(value)
+		case 2:
+			// Repeated embedded messages are merged, as the protobuf spec requires
+			if err := (proto.UnmarshalOptions{Merge: true}).Unmarshal(value, payload); err != nil {
+				return common.Event{}, id, fmt.Errorf("invalid payload: %w", err)
+			}
+		case 3:
+			id = string(value)
+		}
+	}
+	if event.Type == "" {
+		return common.Event{}, id, errMissingType
+	}
+	event.Payload = normalizeNumbers(payload.AsMap()).(map[string]interface{})
+	return event, id, nil
+}
+
+// normalizeNumbers turns decoded numbers into json.Number so events look the same
+// as the ones decoded from JSON
+func normalizeNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if v == nil {
+			return map[string]interface{}{}
+		}
+		for key, item := range v {
+			v[key] = normalizeNumbers(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeNumbers(item)
+		}
+		return v
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10))
+	case uint64:
+		return json.Number(strconv.FormatUint(v, 10))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return v
+		}
+		return json.Number(strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	return value
+}
@@ -0,0 +1,169 @@
+package receiver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func protobufEvent(t *testing.T, eventType string, payload map[string]interface{}, id string) []byte {
+	t.Helper()
+	s, err := structpb.NewStruct(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := proto.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var frame []byte
+	frame = protowire.AppendTag(frame, 1, protowire.BytesType)
+	frame = protowire.AppendString(frame, eventType)
+	frame = protowire.AppendTag(frame, 2, protowire.BytesType)
+	frame = protowire.AppendBytes(frame, encoded)
+	// Unknown fields from newer schemas are skipped
+	frame = protowire.AppendTag(frame, 9, protowire.VarintType)
+	frame = protowire.AppendVarint(frame, 42)
+	if id != "" {
+		frame = protowire.AppendTag(frame, 3, protowire.BytesType)
+		frame = protowire.AppendString(frame, id)
+	}
+	return frame
+}
+
+func msgpackEventFrame(t *testing.T, event map[string]interface{}) []byte {
+	t.Helper()
+	frame, err := msgpack.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func TestDecodeBinaryEvents(t *testing.T) {
+	payload := map[string]interface{}{
+		"machine_id": "4",
+		"count":      12,
+		"ratio":      0.5,
+		"running":    true,
+		"tags":       []interface{}{"a", 1},
+		"engine":     map[string]interface{}{"rpm": 1800},
+	}
+	want := `{"count":12,"engine":{"rpm":1800},"machine_id":"4","ratio":0.5,"running":true,"tags":["a",1]}`
+
+	tests := []struct {
+		name   string
+		decode frameDecoder
+		frame  []byte
+		id     string
+	}{
+		{"msgpack", decodeMsgpackEvent, msgpackEventFrame(t, map[string]interface{}{"type": "clutch_testing_events", "payload": payload, "id": 7}), "7"},
+		{"protobuf", decodeProtobufEvent, protobufEvent(t, "clutch_testing_events", payload, "p-1"), "p-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, id, err := tt.decode(tt.frame)
+			if err != nil {
+				t.Fatalf("decode error = %v", err)
+			}
+			if event.Type != "clutch_testing_events" || id != tt.id {
+				t.Errorf("type/id = %q/%q", event.Type, id)
+			}
+			if _, ok := event.Payload["count"].(json.Number); !ok {
+				t.Errorf("count = %T, want json.Number", event.Payload["count"])
+			}
+			got, _ := json.Marshal(event.Payload)
+			if string(got) != want {
+				t.Errorf("payload = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeMsgpackIntegerKeys(t *testing.T) {
+	payload := map[string]interface{}{
+		"gears": map[int]interface{}{1: 2.5, 2: map[int]string{3: "low"}},
+	}
+	event, _, err := decodeMsgpackEvent(msgpackEventFrame(t, map[string]interface{}{"type": "a", "payload": payload}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.Marshal(event.Payload)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	if want := `{"gears":{"1":2.5,"2":{"3":"low"}}}`; string(got) != want {
+		t.Errorf("payload = %s, want %s", got, want)
+	}
+}
+
+func TestDecodeBinaryEventErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		decode frameDecoder
+		frame  []byte
+	}{
+		{"msgpack missing type", decodeMsgpackEvent, msgpackEventFrame(t, map[string]interface{}{"payload": map[string]interface{}{}})},
+		{"msgpack garbage", decodeMsgpackEvent, []byte{0xc1}},
+		{"msgpack payload not a map", decodeMsgpackEvent, msgpackEventFrame(t, map[string]interface{}{"type": "a", "payload": "text"})},
+		{"protobuf missing type", decodeProtobufEvent, protobufEvent(t, "", map[string]interface{}{}, "")},
+		{"protobuf truncated", decodeProtobufEvent, protobufEvent(t, "a", map[string]interface{}{"x": 1}, "")[:5]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := tt.decode(tt.frame); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestWebSocketSubprotocols(t *testing.T) {
+	r, eventChan := newTestReceiver(10)
+	server := httptest.NewServer(http.HandlerFunc(r.HandleWebSocket))
+	defer server.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{ProtobufSubprotocol}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws?ack=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.Subprotocol() != ProtobufSubprotocol {
+		t.Fatalf("subprotocol = %q, want %q", conn.Subprotocol(), ProtobufSubprotocol)
+	}
+
+	frame := protobufEvent(t, "clutch_testing_events", map[string]interface{}{"machine_id": "4"}, "1")
+	if err := conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		t.Fatal(err)
+	}
+	var ack Ack
+	if err := conn.ReadJSON(&ack); err != nil {
+		t.Fatal(err)
+	}
+	if ack.ID != "1" || ack.Status != AckStatus {
+		t.Errorf("ack = %+v", ack)
+	}
+	if event := <-eventChan; event.Payload["machine_id"] != "4" {
+		t.Errorf("event = %+v", event)
+	}
+
+	// Text frames stay JSON on every subprotocol
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"id":"2","type":"a","payload":{}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.ReadJSON(&ack); err != nil {
+		t.Fatal(err)
+	}
+	if ack.ID != "2" || ack.Status != AckStatus {
+		t.Errorf("ack = %+v", ack)
+	}
+}
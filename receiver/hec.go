@@ -0,0 +1,257 @@
+package receiver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"clutch/common"
+)
+
+const (
+	defaultHECType = "hec"
+	maxHECBodySize = 10 << 20
+	hecAuthScheme  = "Splunk"
+)
+
+// Status codes of the Splunk HTTP Event Collector, clients key their retries off them
+const (
+	hecSuccess       = 0
+	hecTokenDisabled = 1
+	hecTokenRequired = 2
+	hecInvalidAuth   = 3
+	hecInvalidToken  = 4
+	hecNoData        = 5
+	hecInvalidFormat = 6
+	hecInternalError = 8
+	hecServerBusy    = 9
+	hecEventRequired = 12
+	hecEventBlank    = 13
+	hecHealthy       = 17
+)
+
+// hecEnvelope is a single event as sent by HEC clients
+type hecEnvelope struct {
+	Time       interface{}            `json:"time"`
+	Host       string                 `json:"host"`
+	Source     string                 `json:"source"`
+	Sourcetype string                 `json:"sourcetype"`
+	Index      string                 `json:"index"`
+	Event      interface{}            `json:"event"`
+	Fields     map[string]interface{} `json:"fields"`
+}
+
+// HECResponse is the body of every HEC response
+type HECResponse struct {
+	Text               string `json:"text"`
+	Code               int    `json:"code"`
+	InvalidEventNumber *int   `json:"invalid-event-number,omitempty"`
+}
+
+// hecError is an envelope that can not be turned into an event
+type hecError struct {
+	code   int
+	text   string
+	number int
+}
+
+func (e *hecError) Error() string {
+	return e.text
+}
+
+func writeHEC(w http.ResponseWriter, status int, code int, text string) {
+	writeJSON(w, status, HECResponse{Text: text, Code: code})
+}
+
+// hecAuthorized checks the "Authorization: Splunk <token>" header against the configured tokens
+func hecAuthorized(w http.ResponseWriter, req *http.Request, tokens []string) bool {
+	if len(tokens) == 0 {
+		writeHEC(w, http.StatusForbidden, hecTokenDisabled, "Token disabled")
+		return false
+	}
+	header := req.Header.Get("Authorization")
+	if header == "" {
+		writeHEC(w, http.StatusUnauthorized, hecTokenRequired, "Token is required")
+		return false
+	}
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, hecAuthScheme) {
+		writeHEC(w, http.StatusUnauthorized, hecInvalidAuth, "Invalid authorization")
+		return false
+	}
+	for _, valid := range tokens {
+		if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(valid)) == 1 {
+			return true
+		}
+	}
+	fmt.Println("Rejected HEC request with an unknown token from", req.RemoteAddr)
+	writeHEC(w, http.StatusForbidden, hecInvalidToken, "Invalid token")
+	return false
+}
+
+// HandleHECHealth answers the health check HEC clients run before sending
+func (r *Receiver) HandleHECHealth(w http.ResponseWriter, req *http.Request) {
+	writeHEC(w, http.StatusOK, hecHealthy, "HEC is healthy")
+}
+
+// HandleHEC implements the Splunk HTTP Event Collector event endpoint. The body
+// holds one or more concatenated JSON envelopes, each becomes an event whose
+// type is its sourcetype.
+func (r *Receiver) HandleHEC(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	hecCfg := common.GetConfig().Inputs.HEC
+	if !hecAuthorized(w, req, hecCfg.Tokens) {
+		return
+	}
+
+	body, err := decompress(req, http.MaxBytesReader(w, req.Body, maxHECBodySize))
+	if err != nil {
+		writeHEC(w, http.StatusBadRequest, hecInvalidFormat, "Invalid data format")
+		return
+	}
+	events, err := decodeHEC(body, hecCfg.Type)
+	if err != nil {
+		var invalid *hecError
+		if !errors.As(err, &invalid) {
+			invalid = &hecError{code: hecInvalidFormat, text: "Invalid data format", number: len(events)}
+		}
+		fmt.Printf("Rejected HEC event %d (HandleHEC): %v\n", invalid.number, err)
+		writeJSON(w, http.StatusBadRequest, HECResponse{Text: invalid.text, Code: invalid.code, InvalidEventNumber: &invalid.number})
+		return
+	}
+	if len(events) == 0 {
+		writeHEC(w, http.StatusBadRequest, hecNoData, "No data")
+		return
+	}
+
+	for i, event := range events {
+		if err := authorize(req.Context(), event.Type); err != nil {
+			fmt.Printf("Rejected HEC event %d (HandleHEC): %v\n", i, err)
+			writeJSON(w, http.StatusBadRequest, HECResponse{Text: err.Error(), Code: hecInvalidFormat, InvalidEventNumber: &i})
+			return
+		}
+	}
+
+	if err := limit(req.Context(), len(events)); err != nil {
+		var tooLarge *batchTooLargeError
+		if errors.As(err, &tooLarge) {
+			fmt.Printf("Rejected HEC request from %s (HandleHEC): %v\n", req.RemoteAddr, err)
+			writeHEC(w, http.StatusRequestEntityTooLarge, hecInvalidFormat, err.Error())
+			return
+		}
+		wait, _ := retryAfter(err)
+		fmt.Printf("Rate limited HEC request from %s (HandleHEC): %v\n", req.RemoteAddr, err)
+		setRetryAfter(w, wait)
+		writeHEC(w, http.StatusServiceUnavailable, hecServerBusy, "Server is busy")
+		return
+	}
+	// HEC clients resend the whole batch on 503, so once the first event is queued the
+	// rest wait for room instead of being rejected
+	for i, event := range events {
+		if err := r.send(req.Context(), event); err != nil {
+			fmt.Printf("Error queueing HEC event (HandleHEC): %d of %d events queued: %v\n", i, len(events), err)
+			writeHEC(w, http.StatusInternalServerError, hecInternalError, "Internal server error")
+			return
+		}
+	}
+	writeHEC(w, http.StatusOK, hecSuccess, "Success")
+}
+
+// decodeHEC reads every envelope of a body, stopping at the first invalid one
+func decodeHEC(body io.Reader, defaultType string) ([]common.Event, error) {
+	if defaultType == "" {
+		defaultType = defaultHECType
+	}
+	var events []common.Event
+	decoder := json.NewDecoder(body)
+	decoder.UseNumber()
+	for {
+		var envelope hecEnvelope
+		err := decoder.Decode(&envelope)
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		event, invalid := envelope.toEvent(defaultType)
+		if invalid != nil {
+			invalid.number = len(events)
+			return events, invalid
+		}
+		events = append(events, event)
+	}
+}
+
+// toEvent keeps object events as the payload and wraps anything else as a message.
+// The envelope metadata is added unless the event already has the same fields.
+func (e hecEnvelope) toEvent(defaultType string) (common.Event, *hecError) {
+	var payload common.M
+	switch event := e.Event.(type) {
+	case nil:
+		return common.Event{}, &hecError{code: hecEventRequired, text: "Event field is required"}
+	case string:
+		if strings.TrimSpace(event) == "" {
+			return common.Event{}, &hecError{code: hecEventBlank, text: "Event field cannot be blank"}
+		}
+		payload = common.M{"message": event}
+	case map[string]interface{}:
+		payload = event
+	default:
+		payload = common.M{"message": event}
+	}
+
+	setDefault := func(key string, value interface{}) {
+		if _, ok := payload[key]; !ok {
+			payload[key] = value
+		}
+	}
+	if e.Host != "" {
+		setDefault("host", e.Host)
+	}
+	if e.Source != "" {
+		setDefault("source", e.Source)
+	}
+	if timestamp, ok := hecTime(e.Time); ok {
+		setDefault("time", timestamp.UTC().Format(time.RFC3339Nano))
+	}
+	if len(e.Fields) > 0 {
+		setDefault("fields", e.Fields)
+	}
+
+	eventType := e.Sourcetype
+	if eventType == "" {
+		eventType = defaultType
+	}
+	return common.Event{Type: eventType, Payload: payload}, nil
+}
+
+// hecTime reads epoch seconds, sent either as a number or a string, with optional fractions
+func hecTime(value interface{}) (time.Time, bool) {
+	var raw string
+	switch v := value.(type) {
+	case json.Number:
+		raw = v.String()
+	case string:
+		raw = v
+	default:
+		return time.Time{}, false
+	}
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil || seconds <= 0 {
+		return time.Time{}, false
+	}
+	whole, fraction := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(math.Round(fraction*1e6))*1e3), true
+}
@@ -0,0 +1,133 @@
+package receiver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"clutch/common"
+)
+
+func postHEC(t *testing.T, r *Receiver, auth string, body string) (int, HECResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/services/collector/event", strings.NewReader(body))
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	rec := httptest.NewRecorder()
+	r.HandleHEC(rec, req)
+
+	var response HECResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("error decoding response %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, response
+}
+
+func TestHandleHEC(t *testing.T) {
+	cfg := common.GetConfig()
+	defer common.SetConfig(cfg)
+	testCfg := cfg
+	testCfg.Inputs.HEC = common.HECConfig{Tokens: []string{"abc-123"}}
+	common.SetConfig(testCfg)
+
+	tests := []struct {
+		name    string
+		auth    string
+		body    string
+		status  int
+		code    int
+		invalid int
+		events  int
+	}{
+		{"missing token", "", `{"event":"x"}`, http.StatusUnauthorized, hecTokenRequired, -1, 0},
+		{"wrong scheme", "Bearer abc-123", `{"event":"x"}`, http.StatusUnauthorized, hecInvalidAuth, -1, 0},
+		{"unknown token", "Splunk nope", `{"event":"x"}`, http.StatusForbidden, hecInvalidToken, -1, 0},
+		{"no data", "Splunk abc-123", ``, http.StatusBadRequest, hecNoData, -1, 0},
+		{"missing event", "Splunk abc-123", `{"event":"x"}{"host":"h"}`, http.StatusBadRequest, hecEventRequired, 1, 0},
+		{"blank event", "Splunk abc-123", `{"event":" "}`, http.StatusBadRequest, hecEventBlank, 0, 0},
+		{"bad json", "Splunk abc-123", `{"event":"x"}{"event":`, http.StatusBadRequest, hecInvalidFormat, 1, 0},
+		{"batch", "Splunk abc-123", `{"event":"x"} {"event":{"a":1}}`, http.StatusOK, hecSuccess, -1, 2},
+		{"chat question", "Splunk abc-123", `{"event":"x"} {"event":"q","sourcetype":"chat"}`, http.StatusBadRequest, hecInvalidFormat, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, eventChan := newTestReceiver(10)
+			status, response := postHEC(t, r, tt.auth, tt.body)
+			if status != tt.status || response.Code != tt.code {
+				t.Errorf("status/code = %d/%d, want %d/%d", status, response.Code, tt.status, tt.code)
+			}
+			if tt.invalid >= 0 && (response.InvalidEventNumber == nil || *response.InvalidEventNumber != tt.invalid) {
+				t.Errorf("invalid-event-number = %v, want %d", response.InvalidEventNumber, tt.invalid)
+			}
+			if len(eventChan) != tt.events {
+				t.Errorf("queued %d events, want %d", len(eventChan), tt.events)
+			}
+		})
+	}
+}
+
+func TestHandleHECEnvelope(t *testing.T) {
+	cfg := common.GetConfig()
+	defer common.SetConfig(cfg)
+	testCfg := cfg
+	testCfg.Inputs.HEC = common.HECConfig{Tokens: []string{"abc-123"}, Type: "splunk"}
+	common.SetConfig(testCfg)
+
+	r, eventChan := newTestReceiver(10)
+	body := `{"time":1700000000.25,"host":"web-1","source":"/var/log/app.log","sourcetype":"access_combined","fields":{"dc":"east"},"event":{"status":200,"host":"inner"}}
+{"time":"1700000000","event":"plain text"}`
+	if status, response := postHEC(t, r, "Splunk abc-123", body); status != http.StatusOK {
+		t.Fatalf("status = %d, response = %+v", status, response)
+	}
+
+	first := <-eventChan
+	if first.Type != "access_combined" {
+		t.Errorf("type = %q, want access_combined", first.Type)
+	}
+	want := common.M{"host": "inner", "source": "/var/log/app.log", "time": "2023-11-14T22:13:20.25Z"}
+	for key, value := range want {
+		if first.Payload[key] != value {
+			t.Errorf("%s = %v, want %v", key, first.Payload[key], value)
+		}
+	}
+	if fields, _ := first.Payload["fields"].(map[string]interface{}); fields["dc"] != "east" {
+		t.Errorf("fields = %v", first.Payload["fields"])
+	}
+
+	second := <-eventChan
+	if second.Type != "splunk" || second.Payload["message"] != "plain text" || second.Payload["time"] != "2023-11-14T22:13:20Z" {
+		t.Errorf("event = %+v", second)
+	}
+}
+
+func TestHandleHECWaitsForQueue(t *testing.T) {
+	cfg := common.GetConfig()
+	defer common.SetConfig(cfg)
+	testCfg := cfg
+	testCfg.Inputs.HEC = common.HECConfig{Tokens: []string{"abc-123"}}
+	common.SetConfig(testCfg)
+
+	// The batch does not fit the queue, it is taken once there is room instead of
+	// answering 503 with part of it queued
+	r, eventChan := newTestReceiver(1)
+	received := make(chan []string)
+	go func() {
+		var types []string
+		for i := 0; i < 3; i++ {
+			event := <-eventChan
+			types = append(types, event.Payload["message"].(string))
+		}
+		received <- types
+	}()
+	status, response := postHEC(t, r, "Splunk abc-123", `{"event":"a"}{"event":"b"}{"event":"c"}`)
+	if status != http.StatusOK || response.Code != hecSuccess {
+		t.Errorf("status/code = %d/%d, want 200/%d", status, response.Code, hecSuccess)
+	}
+	if types := <-received; !reflect.DeepEqual(types, []string{"a", "b", "c"}) {
+		t.Errorf("queued %v", types)
+	}
+}
@@ -0,0 +1,198 @@
+package receiver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"clutch/common"
+)
+
+// Maximum size of a single POST /events body
+const maxIngestBodySize = 10 << 20
+
+var (
+	errQueueFull   = errors.New("event queue is full")
+	errMissingType = errors.New("event type is required")
+)
+
+// IngestResult is the status of a single event submitted over HTTP
+type IngestResult struct {
+	Index  int    `json:"index"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// IngestResponse is written back for every POST /events request
+type IngestResponse struct {
+	Accepted int            `json:"accepted"`
+	Rejected int            `json:"rejected"`
+	Results  []IngestResult `json:"results"`
+}
+
+// decodeEvent decodes a single JSON encoded event the same way for every ingest path
+func decodeEvent(message []byte) (common.Event, error) {
+	var event common.Event
+	decoder := json.NewDecoder(bytes.NewReader(message))
+	decoder.UseNumber() // This helps preserve number precision
+	if err := decoder.Decode(&event); err != nil {
+		return common.Event{}, err
+	}
+	return event, nil
+}
+
+// decodeIngestEvent is decodeEvent for the HTTP endpoints, which require a type.
+// /ws still forwards events without one, as it always has.
+func decodeIngestEvent(message []byte) (common.Event, error) {
+	event, err := decodeEvent(message)
+	if err == nil && event.Type == "" {
+		return common.Event{}, errMissingType
+	}
+	return event, err
+}
+
+// withMeta stamps an event with its envelope, from what the request context knows about
+// the sender. An id set by the handler is kept, _bulk takes it from the action's _id.
+func withMeta(ctx context.Context, event common.Event) common.Event {
+	conn, _ := ctx.Value(connInfoContextKey{}).(connInfo)
+	id := event.Meta.ID
+	event.Meta = common.NewEventMeta(conn.listener, conn.remoteAddr)
+	if id != "" {
+		event.Meta.ID = id
+	}
+	if identity, ok := ctx.Value(identityContextKey{}).(string); ok {
+		event.Meta.Identity = identity
+	} else if key, ok := ctx.Value(apiKeyContextKey{}).(*apiKey); ok {
+		event.Meta.Identity = key.name
+	}
+	return event
+}
+
+// enqueue pushes an event to the event channel without blocking the caller
+func (r *Receiver) enqueue(ctx context.Context, event common.Event) error {
+	event = withMeta(ctx, event)
+	if err := r.logEvent(&event); err != nil {
+		return err
+	}
+	select {
+	case *r.eventChan <- event:
+		return nil
+	default:
+		r.discard(event)
+		return errQueueFull
+	}
+}
+
+// send waits for room in the event channel until ctx is done
+func (r *Receiver) send(ctx context.Context, event common.Event) error {
+	event = withMeta(ctx, event)
+	if err := r.logEvent(&event); err != nil {
+		return err
+	}
+	select {
+	case *r.eventChan <- event:
+		return nil
+	case <-ctx.Done():
+		r.discard(event)
+		return ctx.Err()
+	}
+}
+
+// splitEvents returns the raw messages of a body holding either a single event or an array of events
+func splitEvents(body []byte) ([]json.RawMessage, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return nil, errors.New("empty request body")
+	}
+	if trimmed[0] != '[' {
+		return []json.RawMessage{trimmed}, nil
+	}
+	var messages []json.RawMessage
+	if err := json.Unmarshal(trimmed, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// decompress unwraps gzip encoded request bodies
+func decompress(req *http.Request, body io.Reader) (io.Reader, error) {
+	if req.Header.Get("Content-Encoding") != "gzip" {
+		return body, nil
+	}
+	return gzip.NewReader(body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Println("Error writing response:", err)
+	}
+}
+
+func (r *Receiver) HandleIngest(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxIngestBodySize))
+	if err != nil {
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, fmt.Sprintf("error reading body: %v", err), status)
+		return
+	}
+
+	messages, err := splitEvents(body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error decoding JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := limit(req.Context(), len(messages)); err != nil {
+		writeRateLimited(w, req, err)
+		return
+	}
+
+	response := IngestResponse{Results: make([]IngestResult, 0, len(messages))}
+	for i, message := range messages {
+		result := IngestResult{Index: i, Status: http.StatusAccepted}
+		event, err := decodeIngestEvent(message)
+		if err != nil {
+			result.Status = http.StatusBadRequest
+			result.Error = err.Error()
+		} else if err := authorize(req.Context(), event.Type); err != nil {
+			result.Status = http.StatusForbidden
+			result.Error = err.Error()
+		} else if err := r.enqueue(req.Context(), event); err != nil {
+			result.Status = http.StatusServiceUnavailable
+			result.Error = err.Error()
+		}
+
+		if result.Status == http.StatusAccepted {
+			response.Accepted++
+		} else {
+			fmt.Printf("Rejected event %d (HandleIngest): %s\n", i, result.Error)
+			response.Rejected++
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	status := http.StatusAccepted
+	switch {
+	case len(response.Results) == 1:
+		status = response.Results[0].Status
+	case response.Rejected > 0:
+		status = http.StatusMultiStatus
+	}
+	writeJSON(w, status, response)
+}
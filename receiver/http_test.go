@@ -0,0 +1,138 @@
+package receiver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"clutch/common"
+
+	"github.com/gorilla/websocket"
+)
+
+func newTestReceiver(size int) (*Receiver, chan common.Event) {
+	eventChan := make(chan common.Event, size)
+	return &Receiver{eventChan: &eventChan, upgrader: websocket.Upgrader{Subprotocols: wsSubprotocols}}, eventChan
+}
+
+func postEvents(t *testing.T, r *Receiver, body string) (*httptest.ResponseRecorder, IngestResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	r.HandleIngest(rec, req)
+
+	var response IngestResponse
+	if rec.Header().Get("Content-Type") == "application/json" {
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("error decoding response %q: %v", rec.Body.String(), err)
+		}
+	}
+	return rec, response
+}
+
+func TestHandleIngestSingleEvent(t *testing.T) {
+	r, eventChan := newTestReceiver(10)
+	rec, response := postEvents(t, r, `{"type":"clutch_testing_events","payload":{"machine_id":"4","count":12}}`)
+
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %v, want %v", rec.Code, http.StatusAccepted)
+	}
+	if response.Accepted != 1 || response.Rejected != 0 {
+		t.Errorf("accepted/rejected = %d/%d, want 1/0", response.Accepted, response.Rejected)
+	}
+	event := <-eventChan
+	if event.Type != "clutch_testing_events" {
+		t.Errorf("event type = %v, want %v", event.Type, "clutch_testing_events")
+	}
+	if _, ok := event.Payload["count"].(json.Number); !ok {
+		t.Errorf("count = %T, want json.Number", event.Payload["count"])
+	}
+}
+
+func TestHandleIngestArray(t *testing.T) {
+	r, eventChan := newTestReceiver(10)
+	rec, response := postEvents(t, r, `[
+		{"type":"a","payload":{"x":1}},
+		{"payload":{"x":2}},
+		{"type":"b","payload":"not an object"}
+	]`)
+
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("status = %v, want %v", rec.Code, http.StatusMultiStatus)
+	}
+	want := []int{http.StatusAccepted, http.StatusBadRequest, http.StatusBadRequest}
+	for i, result := range response.Results {
+		if result.Status != want[i] {
+			t.Errorf("result %d status = %v, want %v", i, result.Status, want[i])
+		}
+	}
+	if len(eventChan) != 1 {
+		t.Errorf("queued events = %d, want 1", len(eventChan))
+	}
+}
+
+func TestHandleIngestQueueFull(t *testing.T) {
+	r, _ := newTestReceiver(1)
+	_, response := postEvents(t, r, `[{"type":"a","payload":{}},{"type":"b","payload":{}}]`)
+
+	if response.Results[1].Status != http.StatusServiceUnavailable {
+		t.Errorf("status = %v, want %v", response.Results[1].Status, http.StatusServiceUnavailable)
+	}
+}
+
+func TestHandleIngestRejectsGet(t *testing.T) {
+	r, _ := newTestReceiver(1)
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	rec := httptest.NewRecorder()
+	r.HandleIngest(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %v, want %v", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+func TestHandleIngestBodyErrors(t *testing.T) {
+	r, _ := newTestReceiver(1)
+	tests := []struct {
+		name string
+		body io.Reader
+		want int
+	}{
+		{"too large", strings.NewReader(strings.Repeat("x", maxIngestBodySize+1)), http.StatusRequestEntityTooLarge},
+		{"read error", failingReader{}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/events", tt.body)
+		rec := httptest.NewRecorder()
+		r.HandleIngest(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %v, want %v", tt.name, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestMissingType(t *testing.T) {
+	message := []byte(`{"payload":{"x":1}}`)
+	if _, err := decodeIngestEvent(message); err != errMissingType {
+		t.Errorf("decodeIngestEvent() = %v, want %v", err, errMissingType)
+	}
+	// The WebSocket forwards events without a type
+	if _, _, err := decodeJSONFrame(message); err != nil {
+		t.Errorf("decodeJSONFrame() = %v", err)
+	}
+}
+
+func TestHandleIngestRejectsChat(t *testing.T) {
+	r, eventChan := newTestReceiver(10)
+	_, response := postEvents(t, r, `{"type":"chat","payload":{"question":"q","session_id":"s"}}`)
+	if response.Results[0].Status != http.StatusForbidden || len(eventChan) != 0 {
+		t.Errorf("result = %+v, %d queued", response.Results[0], len(eventChan))
+	}
+}
@@ -0,0 +1,275 @@
+package receiver
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"clutch/common"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultOTLPType = "otlp_logs"
+	maxOTLPBodySize = 16 << 20
+
+	protobufContentType = "application/x-protobuf"
+	jsonContentType     = "application/json"
+)
+
+// HandleOTLPLogs implements the OTLP/HTTP logs endpoint (POST /v1/logs). Requests
+// are ExportLogsServiceRequest messages, which share their encoding with LogsData.
+func (r *Receiver) HandleOTLPLogs(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	contentType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if contentType != protobufContentType && contentType != jsonContentType {
+		http.Error(w, fmt.Sprintf("unsupported content type %q", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := readOTLPBody(w, req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error reading body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var logs logspb.LogsData
+	if contentType == protobufContentType {
+		err = proto.Unmarshal(body, &logs)
+	} else {
+		err = unmarshalOTLPJSON(body, &logs)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error decoding logs: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	events := otlpLogsToEvents(&logs, common.GetConfig().Inputs.OTLP.Type)
+	// Exporters retry 429 responses after Retry-After
+	if err := limit(req.Context(), len(events)); err != nil {
+		writeRateLimited(w, req, err)
+		return
+	}
+	var rejected otlpRejections
+	forbidden := 0
+	for _, event := range events {
+		if err := authorize(req.Context(), event.Type); err != nil {
+			forbidden++
+			rejected.add(err)
+		} else if err := r.enqueue(req.Context(), event); err != nil {
+			rejected.add(err)
+		}
+	}
+	fmt.Printf("OTLP logs received (HandleOTLPLogs): %d accepted, %d rejected\n", len(events)-rejected.total, rejected.total)
+
+	if forbidden > 0 && forbidden == len(events) {
+		http.Error(w, errForbiddenType.Error(), http.StatusForbidden)
+		return
+	}
+
+	// Nothing was queued, ask the exporter to retry later
+	if rejected.total > 0 && rejected.total == len(events) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, rejected.String(), http.StatusServiceUnavailable)
+		return
+	}
+	writeOTLPResponse(w, contentType, rejected)
+}
+
+// otlpRejections counts the records that were not queued by reason, exporters log the
+// message of a partial success
+type otlpRejections struct {
+	total   int
+	reasons []string
+	counts  map[string]int
+}
+
+func (o *otlpRejections) add(err error) {
+	if o.counts == nil {
+		o.counts = make(map[string]int)
+	}
+	reason := err.Error()
+	if o.counts[reason] == 0 {
+		o.reasons = append(o.reasons, reason)
+	}
+	o.counts[reason]++
+	o.total++
+}
+
+// String lists every reason with its count, e.g. "2 records: event queue is full"
+func (o *otlpRejections) String() string {
+	parts := make([]string, 0, len(o.reasons))
+	for _, reason := range o.reasons {
+		parts = append(parts, fmt.Sprintf("%d records: %s", o.counts[reason], reason))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func readOTLPBody(w http.ResponseWriter, req *http.Request) ([]byte, error) {
+	reader, err := decompress(req, http.MaxBytesReader(w, req.Body, maxOTLPBodySize))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(io.LimitReader(reader, maxOTLPBodySize))
+}
+
+// unmarshalOTLPJSON decodes OTLP/JSON, which differs from plain protojson by
+// encoding trace and span ids as hex instead of base64
+func unmarshalOTLPJSON(body []byte, logs *logspb.LogsData) error {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return err
+	}
+	resourceLogs, _ := raw["resourceLogs"].([]interface{})
+	for _, rl := range resourceLogs {
+		scopeLogs, _ := asMap(rl)["scopeLogs"].([]interface{})
+		for _, sl := range scopeLogs {
+			records, _ := asMap(sl)["logRecords"].([]interface{})
+			for _, record := range records {
+				fields := asMap(record)
+				for _, key := range []string{"traceId", "spanId"} {
+					if id, ok := fields[key].(string); ok {
+						if decoded, err := hex.DecodeString(id); err == nil {
+							fields[key] = base64.StdEncoding.EncodeToString(decoded)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	normalized, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(normalized, logs)
+}
+
+func asMap(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+// writeOTLPResponse writes an ExportLogsServiceResponse, with a partial success when records were dropped
+func writeOTLPResponse(w http.ResponseWriter, contentType string, rejected otlpRejections) {
+	w.Header().Set("Content-Type", contentType)
+	if contentType == jsonContentType {
+		response := map[string]interface{}{}
+		if rejected.total > 0 {
+			response["partialSuccess"] = map[string]interface{}{
+				"rejectedLogRecords": fmt.Sprint(rejected.total),
+				"errorMessage":       rejected.String(),
+			}
+		}
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	var body []byte
+	if rejected.total > 0 {
+		var partial []byte
+		partial = protowire.AppendTag(partial, 1, protowire.VarintType)
+		partial = protowire.AppendVarint(partial, uint64(rejected.total))
+		partial = protowire.AppendTag(partial, 2, protowire.BytesType)
+		partial = protowire.AppendString(partial, rejected.String())
+		body = protowire.AppendTag(body, 1, protowire.BytesType)
+		body = protowire.AppendBytes(body, partial)
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// otlpLogsToEvents turns every log record into an event of the given type
+func otlpLogsToEvents(logs *logspb.LogsData, eventType string) []common.Event {
+	if eventType == "" {
+		eventType = defaultOTLPType
+	}
+	var events []common.Event
+	for _, rl := range logs.GetResourceLogs() {
+		resource := keyValuesToMap(rl.GetResource().GetAttributes())
+		for _, sl := range rl.GetScopeLogs() {
+			scope := common.M{}
+			if name := sl.GetScope().GetName(); name != "" {
+				scope["name"] = name
+			}
+			if version := sl.GetScope().GetVersion(); version != "" {
+				scope["version"] = version
+			}
+
+			for _, record := range sl.GetLogRecords() {
+				payload := common.M{
+					"body":            anyValueToInterface(record.GetBody()),
+					"severity_number": int32(record.GetSeverityNumber()),
+					"severity_text":   record.GetSeverityText(),
+					"attributes":      keyValuesToMap(record.GetAttributes()),
+					"resource":        resource,
+				}
+				if len(scope) > 0 {
+					payload["scope"] = scope
+				}
+				if ts := record.GetTimeUnixNano(); ts > 0 {
+					payload["time"] = time.Unix(0, int64(ts)).UTC().Format(time.RFC3339Nano)
+				}
+				if ts := record.GetObservedTimeUnixNano(); ts > 0 {
+					payload["observed_time"] = time.Unix(0, int64(ts)).UTC().Format(time.RFC3339Nano)
+				}
+				if id := record.GetTraceId(); len(id) > 0 {
+					payload["trace_id"] = hex.EncodeToString(id)
+				}
+				if id := record.GetSpanId(); len(id) > 0 {
+					payload["span_id"] = hex.EncodeToString(id)
+				}
+				events = append(events, common.Event{Type: eventType, Payload: payload})
+			}
+		}
+	}
+	return events
+}
+
+func keyValuesToMap(attributes []*commonpb.KeyValue) map[string]interface{} {
+	m := make(map[string]interface{}, len(attributes))
+	for _, kv := range attributes {
+		m[kv.GetKey()] = anyValueToInterface(kv.GetValue())
+	}
+	return m
+}
+
+func anyValueToInterface(value *commonpb.AnyValue) interface{} {
+	switch v := value.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return v.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return v.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		return v.DoubleValue
+	case *commonpb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(v.BytesValue)
+	case *commonpb.AnyValue_ArrayValue:
+		values := make([]interface{}, 0, len(v.ArrayValue.GetValues()))
+		for _, item := range v.ArrayValue.GetValues() {
+			values = append(values, anyValueToInterface(item))
+		}
+		return values
+	case *commonpb.AnyValue_KvlistValue:
+		return keyValuesToMap(v.KvlistValue.GetValues())
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package receiver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func testLogsData() *logspb.LogsData {
+	str := func(s string) *commonpb.AnyValue {
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}}
+	}
+	return &logspb.LogsData{
+		ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				{Key: "service.name", Value: str("planter-api")},
+			}},
+			ScopeLogs: []*logspb.ScopeLogs{{
+				Scope: &commonpb.InstrumentationScope{Name: "planter"},
+				LogRecords: []*logspb.LogRecord{{
+					TimeUnixNano:   1704103200000000000,
+					SeverityNumber: logspb.SeverityNumber_SEVERITY_NUMBER_WARN,
+					SeverityText:   "WARN",
+					Body:           str("seed hopper low"),
+					Attributes: []*commonpb.KeyValue{
+						{Key: "machine_id", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 4}}},
+					},
+					TraceId: []byte{0x5b, 0x8e, 0xfb, 0xf2, 0x98, 0x51, 0x55, 0x4c, 0x39, 0x9e, 0x8e, 0x3c, 0x58, 0x7d, 0x0b, 0x1f},
+					SpanId:  []byte{0xee, 0xe1, 0x9b, 0x7e, 0xc3, 0xc1, 0xb1, 0x74},
+				}},
+			}},
+		}},
+	}
+}
+
+func TestHandleOTLPLogsProtobuf(t *testing.T) {
+	r, eventChan := newTestReceiver(10)
+	body, err := proto.Marshal(testLogsData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(body)
+	gz.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/logs", &compressed)
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	r.HandleOTLPLogs(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Fatalf("response = %v %q, want an empty 200", rec.Code, rec.Body.String())
+	}
+	event := <-eventChan
+	if event.Type != defaultOTLPType {
+		t.Errorf("type = %v, want %v", event.Type, defaultOTLPType)
+	}
+	checks := map[string]interface{}{
+		"body":            "seed hopper low",
+		"severity_text":   "WARN",
+		"severity_number": int32(13),
+		"time":            "2024-01-01T10:00:00Z",
+		"trace_id":        "5b8efbf29851554c399e8e3c587d0b1f",
+		"span_id":         "eee19b7ec3c1b174",
+	}
+	for key, want := range checks {
+		if event.Payload[key] != want {
+			t.Errorf("%s = %v (%T), want %v", key, event.Payload[key], event.Payload[key], want)
+		}
+	}
+	if event.Payload["attributes"].(map[string]interface{})["machine_id"] != int64(4) {
+		t.Errorf("attributes = %v", event.Payload["attributes"])
+	}
+	if event.Payload["resource"].(map[string]interface{})["service.name"] != "planter-api" {
+		t.Errorf("resource = %v", event.Payload["resource"])
+	}
+}
+
+func TestHandleOTLPLogsJSON(t *testing.T) {
+	r, eventChan := newTestReceiver(10)
+	body := `{"resourceLogs":[{"scopeLogs":[{"logRecords":[
+		{"body":{"stringValue":"one"},"traceId":"5b8efbf29851554c399e8e3c587d0b1f","attributes":[{"key":"n","value":{"intValue":"7"}}]},
+		{"body":{"kvlistValue":{"values":[{"key":"k","value":{"boolValue":true}}]}}}
+	]}]}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/logs", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	r.HandleOTLPLogs(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %v, want %v: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	first, second := <-eventChan, <-eventChan
+	if first.Payload["trace_id"] != "5b8efbf29851554c399e8e3c587d0b1f" {
+		t.Errorf("trace_id = %v", first.Payload["trace_id"])
+	}
+	if first.Payload["attributes"].(map[string]interface{})["n"] != int64(7) {
+		t.Errorf("attributes = %v", first.Payload["attributes"])
+	}
+	if second.Payload["body"].(map[string]interface{})["k"] != true {
+		t.Errorf("body = %v", second.Payload["body"])
+	}
+}
+
+func TestHandleOTLPLogsPartialSuccess(t *testing.T) {
+	r, _ := newTestReceiver(1)
+	logs := testLogsData()
+	records := logs.ResourceLogs[0].ScopeLogs[0].LogRecords
+	logs.ResourceLogs[0].ScopeLogs[0].LogRecords = append(records, records[0])
+	body, _ := proto.Marshal(logs)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/logs", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	rec := httptest.NewRecorder()
+	r.HandleOTLPLogs(rec, req)
+
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "records: event queue is full") {
+		t.Errorf("response = %v %q, want a partial success", rec.Code, rec.Body.String())
+	}
+}
+
+func TestOTLPRejections(t *testing.T) {
+	var rejected otlpRejections
+	rejected.add(errQueueFull)
+	rejected.add(errors.New("event of 70000000 bytes is too large for the write-ahead log"))
+	rejected.add(errQueueFull)
+	want := "2 records: event queue is full; 1 records: event of 70000000 bytes is too large for the write-ahead log"
+	if rejected.total != 3 || rejected.String() != want {
+		t.Errorf("rejections = %d %q, want 3 %q", rejected.total, rejected.String(), want)
+	}
+}
+
+func TestHandleOTLPLogsContentType(t *testing.T) {
+	r, _ := newTestReceiver(1)
+	req := httptest.NewRequest(http.MethodPost, "/v1/logs", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	r.HandleOTLPLogs(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("status = %v, want %v", rec.Code, http.StatusUnsupportedMediaType)
+	}
+}
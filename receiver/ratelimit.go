@@ -0,0 +1,138 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"clutch/common"
+
+	"golang.org/x/time/rate"
+)
+
+// WebSocket close code asking the client to reconnect later (RFC 6455 registry)
+const closeTryAgainLater = 1013
+
+// Reason of nacks for events over the rate limit
+const ReasonRateLimited = "rate_limited"
+
+// rateLimitError is returned for events over the limit of the connection or API key
+type rateLimitError struct {
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry after %s", e.retryAfter.Round(time.Millisecond))
+}
+
+// batchTooLargeError is returned for batches bigger than a bucket can ever hold,
+// retrying them would never succeed
+type batchTooLargeError struct {
+	events, burst int
+}
+
+func (e *batchTooLargeError) Error() string {
+	return fmt.Sprintf("batch of %d events is over the rate limit burst of %d, send smaller batches", e.events, e.burst)
+}
+
+// limiterContextKey stores the bucket of a connection on its context
+type limiterContextKey struct{}
+
+func newLimiter(limit common.RateLimit) *rate.Limiter {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = int(math.Ceil(limit.Rate))
+	}
+	return rate.NewLimiter(rate.Limit(limit.Rate), burst)
+}
+
+// LoadRateLimits sets the limits of new connections and gives every API key its
+// bucket, so it has to run after LoadAPIKeys
+func (r *Receiver) LoadRateLimits(cfg common.RateLimitConfig) {
+	r.rateLimits = cfg
+	for _, key := range r.apiKeys {
+		limit := cfg.APIKey
+		if key.rateLimit != nil {
+			limit = *key.rateLimit
+		}
+		key.limiter = newLimiter(limit)
+	}
+}
+
+// connContext gives every connection its own bucket, HTTP keep-alive requests share it
+func (r *Receiver) connContext(ctx context.Context, _ net.Conn) context.Context {
+	if limiter := newLimiter(r.rateLimits.Connection); limiter != nil {
+		return context.WithValue(ctx, limiterContextKey{}, limiter)
+	}
+	return ctx
+}
+
+// limit takes n events from the buckets of the connection and API key of the request.
+// Nothing is taken when either of them is short, the error tells how long to wait,
+// or that n is more than a full bucket.
+func limit(ctx context.Context, n int) error {
+	var limiters []*rate.Limiter
+	if limiter, ok := ctx.Value(limiterContextKey{}).(*rate.Limiter); ok {
+		limiters = append(limiters, limiter)
+	}
+	if key, ok := ctx.Value(apiKeyContextKey{}).(*apiKey); ok && key.limiter != nil {
+		limiters = append(limiters, key.limiter)
+	}
+
+	for _, limiter := range limiters {
+		if n > limiter.Burst() {
+			return &batchTooLargeError{events: n, burst: limiter.Burst()}
+		}
+	}
+
+	now := time.Now()
+	var wait time.Duration
+	reservations := make([]*rate.Reservation, 0, len(limiters))
+	for _, limiter := range limiters {
+		reservation := limiter.ReserveN(now, n)
+		reservations = append(reservations, reservation)
+		wait = max(wait, reservation.DelayFrom(now))
+	}
+	if wait == 0 {
+		return nil
+	}
+	for _, reservation := range reservations {
+		reservation.CancelAt(now)
+	}
+	return &rateLimitError{retryAfter: wait}
+}
+
+// retryAfter returns the wait of a rate limit error
+func retryAfter(err error) (time.Duration, bool) {
+	var limited *rateLimitError
+	if !errors.As(err, &limited) {
+		return 0, false
+	}
+	return limited.retryAfter, true
+}
+
+// setRetryAfter sets the Retry-After header in whole seconds, at least one
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(wait.Seconds())))))
+}
+
+func writeRateLimited(w http.ResponseWriter, req *http.Request, err error) {
+	var tooLarge *batchTooLargeError
+	if errors.As(err, &tooLarge) {
+		fmt.Printf("Rejected request to %s from %s: %v\n", req.URL.Path, req.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	wait, _ := retryAfter(err)
+	fmt.Printf("Rate limited request to %s from %s: %v\n", req.URL.Path, req.RemoteAddr, err)
+	setRetryAfter(w, wait)
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+}
@@ -0,0 +1,214 @@
+package receiver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"clutch/common"
+
+	"github.com/gorilla/websocket"
+)
+
+// Refills so slowly that tests never see a new token
+var slowBucket = common.RateLimit{Rate: 0.001, Burst: 2}
+
+func newRateLimitedServer(t *testing.T, r *Receiver, handlers ...string) *httptest.Server {
+	t.Helper()
+	mux, err := r.NewMux(handlers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(mux)
+	server.Config.ConnContext = r.connContext
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestLimit(t *testing.T) {
+	ctx := context.WithValue(context.Background(), limiterContextKey{}, newLimiter(slowBucket))
+	if err := limit(ctx, 2); err != nil {
+		t.Fatalf("limit(2) = %v", err)
+	}
+	err := limit(ctx, 1)
+	if wait, ok := retryAfter(err); !ok || wait <= 0 {
+		t.Errorf("limit(1) = %v, want a rate limit error with a wait", err)
+	}
+	if _, ok := retryAfter(limit(context.Background(), 1000)); ok {
+		t.Errorf("requests without buckets should not be limited")
+	}
+
+	// A batch larger than the bucket never fits and does not drain it
+	fresh := context.WithValue(context.Background(), limiterContextKey{}, newLimiter(slowBucket))
+	err = limit(fresh, 3)
+	if _, ok := err.(*batchTooLargeError); !ok {
+		t.Errorf("limit(3) with a burst of 2 = %v, want a batch too large error", err)
+	}
+	if _, ok := retryAfter(err); ok {
+		t.Errorf("a batch over the burst should not be retried")
+	}
+	if err := limit(fresh, 2); err != nil {
+		t.Errorf("limit(2) after a failed batch = %v", err)
+	}
+}
+
+func TestRateLimitedHTTP(t *testing.T) {
+	r, _ := newTestReceiver(10)
+	r.LoadRateLimits(common.RateLimitConfig{Connection: slowBucket})
+	server := newRateLimitedServer(t, r, "ingest")
+	client := server.Client()
+
+	post := func(path string, body string) *http.Response {
+		t.Helper()
+		resp, err := client.Post(server.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// Bulk lines over the limit are listed so only they are resent
+	resp := post("/events/bulk", "{\"type\":\"a\",\"payload\":{}}\n{\"type\":\"a\",\"payload\":{}}\n{\"type\":\"a\",\"payload\":{}}\n")
+	var summary BulkSummary
+	if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || summary.Accepted != 2 || summary.Throttled != 1 || summary.Errors[0].Line != 3 {
+		t.Errorf("status = %v, summary = %+v", resp.StatusCode, summary)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Errorf("missing Retry-After header")
+	}
+
+	// The keep-alive connection shares the bucket
+	resp = post("/events", `{"type":"a","payload":{}}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("status = %v, Retry-After = %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+}
+
+func TestBatchOverBurst(t *testing.T) {
+	cfg := common.GetConfig()
+	defer common.SetConfig(cfg)
+	testCfg := cfg
+	testCfg.Inputs.HEC = common.HECConfig{Tokens: []string{"abc-123"}}
+	common.SetConfig(testCfg)
+
+	r, eventChan := newTestReceiver(10)
+	r.LoadRateLimits(common.RateLimitConfig{Connection: slowBucket})
+	server := newRateLimitedServer(t, r, "ingest", "hec")
+
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{"events", "/events", `[{"type":"a","payload":{}},{"type":"a","payload":{}},{"type":"a","payload":{}}]`},
+		{"hec", "/services/collector/event", `{"event":"x"}{"event":"y"}{"event":"z"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, server.URL+tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Splunk abc-123")
+			resp, err := server.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusRequestEntityTooLarge || resp.Header.Get("Retry-After") != "" {
+				t.Errorf("status = %v, Retry-After = %q, want 413 without it", resp.StatusCode, resp.Header.Get("Retry-After"))
+			}
+		})
+	}
+	if len(eventChan) != 0 {
+		t.Errorf("queued %d events", len(eventChan))
+	}
+}
+
+func TestRateLimitedAPIKey(t *testing.T) {
+	r, _ := newAuthReceiver(t, 10)
+	r.LoadRateLimits(common.RateLimitConfig{APIKey: common.RateLimit{Rate: 0.001, Burst: 1}})
+	server := newRateLimitedServer(t, r, "ingest")
+
+	send := func(key string) int {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/events", strings.NewReader(`{"type":"sensor_a","payload":{}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-API-Key", key)
+		// A new connection every time, only the key bucket applies
+		req.Close = true
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := send("sensor-key"); status != http.StatusAccepted {
+		t.Fatalf("first event status = %v", status)
+	}
+	if status := send("sensor-key"); status != http.StatusTooManyRequests {
+		t.Errorf("second event status = %v, want 429", status)
+	}
+	if status := send("ops-key"); status != http.StatusAccepted {
+		t.Errorf("other key status = %v, want 202 from its own bucket", status)
+	}
+}
+
+func TestRateLimitedWebSocket(t *testing.T) {
+	r, eventChan := newTestReceiver(10)
+	r.LoadRateLimits(common.RateLimitConfig{Connection: slowBucket})
+	server := newRateLimitedServer(t, r, "websocket")
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	frame := []byte(`{"id":"x","type":"a","payload":{}}`)
+
+	// Ack mode nacks the events over the limit
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?ack=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	statuses := []string{}
+	for i := 0; i < 3; i++ {
+		if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+			t.Fatal(err)
+		}
+		var ack Ack
+		if err := conn.ReadJSON(&ack); err != nil {
+			t.Fatal(err)
+		}
+		statuses = append(statuses, ack.Status+"/"+ack.Reason)
+	}
+	if strings.Join(statuses, ",") != "ack/,ack/,nack/rate_limited" {
+		t.Errorf("acks = %v", statuses)
+	}
+
+	// Without acks the connection is closed with 1013
+	conn, _, err = websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := 0; i < 3; i++ {
+		if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, closeTryAgainLater) {
+		t.Errorf("read error = %v, want close code %d", err, closeTryAgainLater)
+	}
+	if len(eventChan) != 4 {
+		t.Errorf("queued events = %d, want 4", len(eventChan))
+	}
+}
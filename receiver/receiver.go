@@ -2,9 +2,14 @@ package receiver
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"sync"
+	"time"
 
 	"encoding/json"
 
@@ -14,23 +19,32 @@ import (
 )
 
 type Receiver struct {
-	SynthChan         *chan common.Event
-	MaskedStorageChan *chan common.Event
-	eventChan         *chan common.Event
-	pipeline          *chan common.Event
-	done              chan struct{}
-	wg                sync.WaitGroup
-	upgrader          websocket.Upgrader
+	eventChan *chan common.Event
+	pipeline  *chan common.Event
+	// Chat questions go straight to the chat service, not through the pipeline
+	chatChan *chan common.Event
+	done     chan struct{}
+	wg       sync.WaitGroup
+	upgrader websocket.Upgrader
+	// Guards servers and closing done
+	mutex   sync.Mutex
+	servers []*http.Server
+	// WebSocket connections, the servers stop tracking them once they are hijacked
+	conns sync.WaitGroup
+	// nil while authentication is off
+	apiKeys    map[[sha256.Size]byte]*apiKey
+	rateLimits common.RateLimitConfig
+	eventLog
 }
 
 func NewReceiver() *Receiver {
 	return &Receiver{
-		SynthChan:         &common.SynthChan,
-		MaskedStorageChan: &common.MaskedStorageChan,
-		eventChan:         &common.EventChan,
-		pipeline:          &common.Pipeline,
-		done:              make(chan struct{}),
+		eventChan: &common.EventChan,
+		pipeline:  &common.Pipeline,
+		chatChan:  &common.ChatChan,
+		done:      make(chan struct{}),
 		upgrader: websocket.Upgrader{
+			Subprotocols: wsSubprotocols,
 			CheckOrigin: func(r *http.Request) bool {
 				return true // Allow all connections for this example
 			},
@@ -42,27 +56,40 @@ func (r *Receiver) Receive() {
 	r.wg.Add(1)
 	go func() {
 		defer r.wg.Done()
-		for {
-			select {
-			case event := <-*r.eventChan:
-				*r.pipeline <- event
-				fmt.Printf("Received event: %+v\n", event)
-			case <-r.done:
-				return
+		// Runs until Close, so the events queued during shutdown are still forwarded
+		for event := range *r.eventChan {
+			// Events of the inputs are stamped when they are sent, anything else here
+			if event.Meta.ID == "" {
+				event.Meta = common.NewEventMeta(event.Meta.Listener, event.Meta.RemoteAddr)
 			}
+			// Everything queued is logged already, the inputs log before they checkpoint
+			r.commitWhenDone(&event)
+			*r.pipeline <- event
+			fmt.Printf("Received event: %+v\n", event)
 		}
+		close(*r.pipeline)
 	}()
 }
 
+// Close forwards the events still queued and closes the pipeline. Call it once the
+// listeners (Shutdown) and the inputs have stopped, nothing may send events afterwards.
+func (r *Receiver) Close() {
+	close(*r.eventChan)
+	r.wg.Wait()
+}
+
 func (r *Receiver) HandleWebSocket(w http.ResponseWriter, req *http.Request) {
-	conn, err := r.upgrader.Upgrade(w, req, nil)
+	conn, release, err := r.upgrade(w, req)
 	if err != nil {
 		fmt.Println("Failed to upgrade connection:", err)
 		return
 	}
+	defer release()
+	ackMode := ackRequested(req)
+	decodeBinary := binaryDecoder(conn.Subprotocol())
 
 	for {
-		_, message, err := conn.ReadMessage()
+		messageType, reader, err := conn.NextReader()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				fmt.Printf("Connection closed unexpectedly (HandleWebSocket): %v\n", err)
@@ -70,56 +97,170 @@ func (r *Receiver) HandleWebSocket(w http.ResponseWriter, req *http.Request) {
 			break
 		}
 
-		fmt.Println("Raw message: (HandleWebSocket)", string(message))
+		// Binary frames carry newline delimited events and get a summary back,
+		// unless the client negotiated a binary encoding for single events
+		if messageType == websocket.BinaryMessage && decodeBinary == nil {
+			summary := r.bulkFromFrame(req.Context(), reader)
+			if err := conn.WriteJSON(summary); err != nil {
+				fmt.Println("Error writing bulk summary (HandleWebSocket):", err)
+				break
+			}
+			continue
+		}
 
-		var event common.Event
-		decoder := json.NewDecoder(bytes.NewReader(message))
-		decoder.UseNumber() // This helps preserve number precision
-		err = decoder.Decode(&event)
+		message, err := io.ReadAll(reader)
 		if err != nil {
-			fmt.Printf("Error decoding JSON: %v\n", err)
+			fmt.Println("Error reading message (HandleWebSocket):", err)
+			break
+		}
+
+		decode := decodeJSONFrame
+		if messageType == websocket.BinaryMessage {
+			decode = decodeBinary
+			fmt.Printf("Raw message: (HandleWebSocket) %d bytes of %s\n", len(message), conn.Subprotocol())
+		} else {
+			fmt.Println("Raw message: (HandleWebSocket)", string(message))
+		}
+
+		if ackMode {
+			if err := conn.WriteJSON(r.ackMessage(req.Context(), message, decode)); err != nil {
+				fmt.Println("Error writing ack (HandleWebSocket):", err)
+				break
+			}
 			continue
 		}
 
+		event, _, err := decode(message)
+		if err != nil {
+			fmt.Printf("Error decoding event: %v\n", err)
+			continue
+		}
+		if err := authorize(req.Context(), event.Type); err != nil {
+			continue
+		}
+		// Without acks the only way to slow the client down is to close the connection
+		if err := limit(req.Context(), 1); err != nil {
+			fmt.Printf("Closing rate limited connection from %s (HandleWebSocket): %v\n", req.RemoteAddr, err)
+			closeMessage := websocket.FormatCloseMessage(closeTryAgainLater, err.Error())
+			conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+			conn.Close()
+			break
+		}
+
 		fmt.Printf("Forwarding event to event channel (HandleWebSocket): %+v\n", event)
-		*r.eventChan <- event
+		if err := r.send(req.Context(), event); err != nil {
+			fmt.Printf("Dropping event (HandleWebSocket): %v\n", err)
+		}
+	}
+}
+
+// ackMessage queues a message without blocking and reports the outcome to the client
+func (r *Receiver) ackMessage(ctx context.Context, message []byte, decode frameDecoder) Ack {
+	event, id, err := decode(message)
+	if err != nil {
+		fmt.Printf("Error decoding event (id %q): %v\n", id, err)
+		return nack(id, ReasonDecodeError, err)
+	}
+	if err := authorize(ctx, event.Type); err != nil {
+		return nack(id, ReasonForbidden, err)
+	}
+	if err := limit(ctx, 1); err != nil {
+		fmt.Printf("Rate limited event (id %q): %v\n", id, err)
+		return nack(id, ReasonRateLimited, err)
+	}
+	if err := r.enqueue(ctx, event); err != nil {
+		fmt.Printf("Dropping event (id %q): %v\n", id, err)
+		return nack(id, ReasonQueueFull, err)
+	}
+	fmt.Printf("Forwarded event to event channel (id %q): %+v\n", id, event)
+	return Ack{ID: id, Status: AckStatus}
+}
+
+// chatRequest is a question sent on /chat, plain text frames are treated as the question
+var errChatBusy = errors.New("too many questions waiting for an answer, ask again later")
+
+type chatRequest struct {
+	Question string `json:"question"`
+	Index    string `json:"index"`
+}
+
+func decodeChatRequest(message []byte) (chatRequest, error) {
+	trimmed := bytes.TrimSpace(message)
+	if len(trimmed) > 0 && trimmed[0] != '{' {
+		return chatRequest{Question: string(trimmed)}, nil
+	}
+	var request chatRequest
+	if err := json.Unmarshal(trimmed, &request); err != nil {
+		return chatRequest{}, err
 	}
+	if request.Question == "" {
+		return chatRequest{}, errors.New("question is required")
+	}
+	return request, nil
 }
 
 func (r *Receiver) HandleChat(w http.ResponseWriter, req *http.Request) {
-	conn, err := r.upgrader.Upgrade(w, req, nil)
+	conn, release, err := r.upgrade(w, req)
 	if err != nil {
 		fmt.Println("Failed to upgrade connection:", err)
 		return
 	}
+	defer conn.Close()
+	defer release()
+
+	session := common.NewSession(req.URL.Query().Get("index"))
+	fmt.Println("Chat session started:", session.ID)
+
+	// Replies are written from a single goroutine, answers arrive from the chat service
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for {
+			select {
+			case reply := <-session.Replies:
+				if err := conn.WriteJSON(reply); err != nil {
+					fmt.Println("Error writing chat reply (HandleChat):", err)
+					return
+				}
+			case <-session.Done():
+				if err := session.Err(); err == common.ErrSlowChatClient {
+					closeMessage := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error())
+					conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+					// Stops the read loop below as well
+					conn.Close()
+				}
+				return
+			}
+		}
+	}()
+	defer func() {
+		session.Close()
+		<-written
+	}()
 
 	for {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				fmt.Printf("Connection closed unexpectedly (HandleWebSocket): %v\n", err)
+				fmt.Printf("Connection closed unexpectedly (HandleChat): %v\n", err)
 			}
 			break
 		}
 
 		fmt.Println("Raw message: (HandleChat)", string(message))
 
-		var event common.Event
-		decoder := json.NewDecoder(bytes.NewReader(message))
-		decoder.UseNumber() // This helps preserve number precision
-		err = decoder.Decode(&event)
+		request, err := decodeChatRequest(message)
 		if err != nil {
-			fmt.Printf("Error decoding JSON: %v\n", err)
+			fmt.Printf("Error decoding chat request: %v\n", err)
+			session.Send(common.Reply{Type: common.ErrorReply, Error: err.Error()})
 			continue
 		}
 
-		fmt.Printf("Forwarding event to event channel (HandleWebSocket): %+v\n", event)
-		*r.eventChan <- event
+		// A busy model turns questions away instead of holding up the connection
+		select {
+		case *r.chatChan <- common.NewChatEvent(session, request.Question, request.Index):
+		default:
+			session.Send(common.Reply{Type: common.ErrorReply, Question: request.Question, Error: errChatBusy.Error()})
+		}
 	}
 }
-
-func (r *Receiver) StartServer(addr string) error {
-	http.HandleFunc("/ws", r.HandleWebSocket)
-	// http.HandleFunc("/chat", r.HandleChat)
-	return http.ListenAndServe(addr, nil)
-}
@@ -0,0 +1,188 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"clutch/common"
+)
+
+// Used when neither the server nor the listener sets a port
+const defaultPort = "8080"
+
+// connInfo describes the connection a request arrived on
+type connInfo struct {
+	listener   string
+	remoteAddr string
+}
+
+type connInfoContextKey struct{}
+
+// handlerGroups maps the handler names of a listener config to the endpoints they serve
+func (r *Receiver) handlerGroups() map[string]map[string]http.HandlerFunc {
+	return map[string]map[string]http.HandlerFunc{
+		"websocket": {
+			"/ws": r.requireAPIKey(r.HandleWebSocket),
+		},
+		"ingest": {
+			"/events":      r.requireAPIKey(r.HandleIngest),
+			"/events/bulk": r.requireAPIKey(r.HandleBulk),
+			"/events/csv":  r.requireAPIKey(r.HandleCSV),
+		},
+		"otlp": {
+			"/v1/logs": r.requireAPIKey(r.HandleOTLPLogs),
+		},
+		"elastic": {
+			"/_bulk":         r.requireAPIKey(r.HandleElasticBulk),
+			"/{index}/_bulk": r.requireAPIKey(r.HandleElasticBulk),
+			"GET /{$}":       r.HandleElasticInfo,
+		},
+		"hec": {
+			"/services/collector":           r.HandleHEC,
+			"/services/collector/event":     r.HandleHEC,
+			"/services/collector/event/1.0": r.HandleHEC,
+			"/services/collector/health":    r.HandleHECHealth,
+		},
+		"chat": {
+			"/chat": r.requireAPIKey(r.HandleChat),
+		},
+		"subscribe": {
+			"/subscribe": r.requireAPIKey(r.HandleSubscribe),
+		},
+	}
+}
+
+// NewMux serves the endpoints of the given handler groups, or of all of them when none
+// are given. /chat is only served with the chat service running, nothing answers it otherwise.
+func (r *Receiver) NewMux(handlers []string) (*http.ServeMux, error) {
+	groups := r.handlerGroups()
+	chatEnabled := false
+	for _, service := range common.GetConfig().Services {
+		chatEnabled = chatEnabled || service == "chat"
+	}
+	if len(handlers) == 0 {
+		for name := range groups {
+			if name != "chat" || chatEnabled {
+				handlers = append(handlers, name)
+			}
+		}
+	}
+
+	mux := http.NewServeMux()
+	registered := make(map[string]bool)
+	for _, name := range handlers {
+		routes, ok := groups[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown handler %q", name)
+		}
+		if name == "chat" && !chatEnabled {
+			return nil, errors.New("the chat handler needs the chat service in services")
+		}
+		if registered[name] {
+			continue
+		}
+		registered[name] = true
+		for pattern, handler := range routes {
+			mux.HandleFunc(pattern, handler)
+		}
+	}
+	return mux, nil
+}
+
+// listeners returns the configured listeners, or a single one on the server host and port
+func listeners(cfg common.ServerConfig) []common.ListenerConfig {
+	if len(cfg.Listeners) > 0 {
+		return cfg.Listeners
+	}
+	return []common.ListenerConfig{{Name: "default", Host: cfg.Host, Port: cfg.Port}}
+}
+
+func (r *Receiver) newServer(listener common.ListenerConfig, serverTLS common.TLSConfig) (*http.Server, error) {
+	mux, err := r.NewMux(listener.Handlers)
+	if err != nil {
+		return nil, err
+	}
+	if listener.TLS != nil {
+		serverTLS = *listener.TLS
+	}
+	tlsConfig, err := newTLSConfig(serverTLS)
+	if err != nil {
+		return nil, err
+	}
+	port := listener.Port
+	if port == "" {
+		port = defaultPort
+	}
+	return &http.Server{
+		Addr:      net.JoinHostPort(listener.Host, port),
+		Handler:   identify(mux),
+		TLSConfig: tlsConfig,
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			info := connInfo{listener: listener.Name, remoteAddr: conn.RemoteAddr().String()}
+			return r.connContext(context.WithValue(ctx, connInfoContextKey{}, info), conn)
+		},
+	}, nil
+}
+
+// StartServers runs every listener of the config. It returns once one of them stops,
+// after closing the others, or with nil once Shutdown has been called.
+func (r *Receiver) StartServers(cfg common.ServerConfig) error {
+	var servers []*http.Server
+	var names []string
+	seen := make(map[string]bool)
+	for i, listener := range listeners(cfg) {
+		if listener.Name == "" {
+			listener.Name = fmt.Sprintf("listener_%d", i)
+		}
+		if seen[listener.Name] {
+			return fmt.Errorf("listener %s: duplicate name", listener.Name)
+		}
+		seen[listener.Name] = true
+
+		server, err := r.newServer(listener, cfg.TLS)
+		if err != nil {
+			return fmt.Errorf("listener %s: %w", listener.Name, err)
+		}
+		servers = append(servers, server)
+		names = append(names, listener.Name)
+	}
+
+	r.mutex.Lock()
+	select {
+	case <-r.done:
+		r.mutex.Unlock()
+		return nil
+	default:
+	}
+	r.servers = append(r.servers, servers...)
+	r.mutex.Unlock()
+
+	errs := make(chan error, len(servers))
+	for i, server := range servers {
+		name := names[i]
+		go func() {
+			var err error
+			if server.TLSConfig != nil {
+				fmt.Printf("Starting listener %s on %s (TLS)\n", name, server.Addr)
+				err = server.ListenAndServeTLS("", "")
+			} else {
+				fmt.Printf("Starting listener %s on %s\n", name, server.Addr)
+				err = server.ListenAndServe()
+			}
+			errs <- fmt.Errorf("listener %s: %w", name, err)
+		}()
+	}
+
+	err := <-errs
+	if errors.Is(err, http.ErrServerClosed) {
+		// Shutdown stops the others gracefully
+		return nil
+	}
+	for _, server := range servers {
+		server.Close()
+	}
+	return err
+}
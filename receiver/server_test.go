@@ -0,0 +1,186 @@
+package receiver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"clutch/common"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestNewMuxHandlers(t *testing.T) {
+	r, _ := newTestReceiver(10)
+	mux, err := r.NewMux([]string{"ingest", "hec", "ingest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method string
+		target string
+		want   int
+	}{
+		{http.MethodPost, "/events", http.StatusAccepted},
+		{http.MethodGet, "/services/collector/health", http.StatusOK},
+		{http.MethodGet, "/chat", http.StatusNotFound},
+		{http.MethodGet, "/ws", http.StatusNotFound},
+		{http.MethodPost, "/v1/logs", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(`{"type":"a","payload":{}}`))
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %v, want %v", rec.Code, tt.want)
+			}
+		})
+	}
+
+	if _, err := r.NewMux([]string{"ingest", "nope"}); err == nil {
+		t.Errorf("expected an error for an unknown handler")
+	}
+}
+
+// withChat runs the test with the chat service configured
+func withChat(t *testing.T) {
+	cfg := common.GetConfig()
+	t.Cleanup(func() { common.SetConfig(cfg) })
+	testCfg := cfg
+	testCfg.Services = []string{"chat"}
+	common.SetConfig(testCfg)
+}
+
+func TestNewMuxChat(t *testing.T) {
+	r, _ := newTestReceiver(10)
+	serves := func(mux *http.ServeMux) bool {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/chat", nil))
+		return rec.Code != http.StatusNotFound
+	}
+
+	// Nothing would answer the questions without the chat service
+	mux, err := r.NewMux(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serves(mux) {
+		t.Errorf("/chat served without the chat service")
+	}
+	if _, err := r.NewMux([]string{"chat"}); err == nil {
+		t.Errorf("expected an error for the chat handler without the chat service")
+	}
+
+	withChat(t)
+	if mux, err = r.NewMux(nil); err != nil {
+		t.Fatal(err)
+	}
+	if !serves(mux) {
+		t.Errorf("/chat not served with the chat service")
+	}
+}
+
+func TestHandleChatBusy(t *testing.T) {
+	r, eventChan := newTestReceiver(1)
+	// Nobody reads the questions, like a chat service stuck on the model
+	chatChan := make(chan common.Event)
+	r.chatChan = &chatChan
+	server := httptest.NewServer(http.HandlerFunc(r.HandleChat))
+	defer server.Close()
+	conn := dialTestServer(t, server, "/chat")
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("how hot is harvester 2?")); err != nil {
+		t.Fatal(err)
+	}
+	var reply common.Reply
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Type != common.ErrorReply || reply.Error != errChatBusy.Error() {
+		t.Errorf("reply = %+v", reply)
+	}
+	if len(eventChan) != 0 {
+		t.Errorf("the question was queued for the pipeline")
+	}
+}
+
+func TestListeners(t *testing.T) {
+	withChat(t)
+	r, _ := newTestReceiver(1)
+	tlsOff := common.TLSConfig{}
+
+	// Without listeners the server host and port are used
+	configs := listeners(common.ServerConfig{Host: "127.0.0.1", Port: "9090"})
+	server, err := r.newServer(configs[0], tlsOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 1 || server.Addr != "127.0.0.1:9090" || server.TLSConfig != nil {
+		t.Errorf("default listener = %+v on %s", configs, server.Addr)
+	}
+
+	cfg := common.ServerConfig{
+		Host: "ignored",
+		Listeners: []common.ListenerConfig{
+			{Name: "ingest", Host: "10.0.0.1", Port: "9000", Handlers: []string{"websocket", "ingest"}},
+			{Name: "chat", Handlers: []string{"chat"}},
+		},
+	}
+	configs = listeners(cfg)
+	if len(configs) != 2 {
+		t.Fatalf("listeners = %+v", configs)
+	}
+	server, err = r.newServer(configs[1], tlsOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.Addr != ":"+defaultPort {
+		t.Errorf("addr = %s, want :%s", server.Addr, defaultPort)
+	}
+
+	// Configuration errors are reported before anything listens
+	for _, bad := range []common.ServerConfig{
+		{Listeners: []common.ListenerConfig{{Name: "a", Port: "0"}, {Name: "a", Port: "0"}}},
+		{Listeners: []common.ListenerConfig{{Name: "a", Port: "0", Handlers: []string{"nope"}}}},
+		{Listeners: []common.ListenerConfig{{Name: "a", Port: "0", TLS: &common.TLSConfig{Cert: "missing.crt", Key: "missing.key"}}}},
+	} {
+		if err := r.StartServers(bad); err == nil {
+			t.Errorf("StartServers(%+v) expected an error", bad)
+		}
+	}
+}
+
+func TestListenerEnvelope(t *testing.T) {
+	r, eventChan := newAuthReceiver(t, 10)
+	server, err := r.newServer(common.ListenerConfig{Name: "internal", Handlers: []string{"ingest"}}, common.TLSConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(server.Handler)
+	ts.Config.ConnContext = server.ConnContext
+	ts.Start()
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/events", strings.NewReader(`[{"type":"a","payload":{}},{"type":"b","payload":{}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-API-Key", "ops-key")
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	first, second := <-eventChan, <-eventChan
+	meta := first.Meta
+	if meta.ID == "" || meta.ID == second.Meta.ID || meta.ReceivedAt.IsZero() {
+		t.Errorf("ids = %q/%q, received at %v", meta.ID, second.Meta.ID, meta.ReceivedAt)
+	}
+	if meta.Listener != "internal" || !strings.HasPrefix(meta.RemoteAddr, "127.0.0.1:") || meta.Identity != "ops" {
+		t.Errorf("meta = %+v", meta)
+	}
+}
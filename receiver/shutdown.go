@@ -0,0 +1,90 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var errShuttingDown = errors.New("server shutting down")
+
+// Shutdown stops every listener and waits for the requests in flight. WebSocket
+// connections are closed with 1001 (going away) and waited for as well, so whatever
+// they acked is queued. The queued events are forwarded until Close.
+func (r *Receiver) Shutdown(ctx context.Context) error {
+	r.mutex.Lock()
+	select {
+	case <-r.done:
+	default:
+		close(r.done)
+	}
+	servers := r.servers
+	r.mutex.Unlock()
+
+	var err error
+	for _, server := range servers {
+		if shutdownErr := server.Shutdown(ctx); shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		r.conns.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-ctx.Done():
+		if err == nil {
+			err = fmt.Errorf("waiting for WebSocket connections: %w", ctx.Err())
+		}
+	}
+	return err
+}
+
+// upgrade counts the WebSocket connection for Shutdown before it is hijacked, so
+// Shutdown waits for the handler, and closes it once shutdown starts. The returned
+// func is called when the handler returns.
+func (r *Receiver) upgrade(w http.ResponseWriter, req *http.Request) (*websocket.Conn, func(), error) {
+	r.mutex.Lock()
+	select {
+	case <-r.done:
+		r.mutex.Unlock()
+		http.Error(w, errShuttingDown.Error(), http.StatusServiceUnavailable)
+		return nil, nil, errShuttingDown
+	default:
+	}
+	r.conns.Add(1)
+	r.mutex.Unlock()
+
+	conn, err := r.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		r.conns.Done()
+		return nil, nil, err
+	}
+	handled := make(chan struct{})
+	go func() {
+		select {
+		case <-r.done:
+			goingAway(conn)
+		case <-handled:
+		}
+	}()
+	return conn, func() {
+		close(handled)
+		r.conns.Done()
+	}, nil
+}
+
+// goingAway tells the client the server is shutting down and closes the connection,
+// the handler reading it returns
+func goingAway(conn *websocket.Conn) {
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+	conn.Close()
+}
@@ -0,0 +1,90 @@
+package receiver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"clutch/common"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestShutdown(t *testing.T) {
+	r, eventChan := newTestReceiver(10)
+	r.done = make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(r.HandleWebSocket))
+	defer server.Close()
+	r.servers = append(r.servers, server.Config)
+	conn := dialTestServer(t, server, "/ws?ack=true")
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"id":"1","type":"a","payload":{}}`)); err != nil {
+		t.Fatal(err)
+	}
+	var ack Ack
+	if err := conn.ReadJSON(&ack); err != nil || ack.Status != AckStatus {
+		t.Fatalf("ack = %+v, %v", ack, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	if len(eventChan) != 1 {
+		t.Errorf("queued events = %d, want 1", len(eventChan))
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("read after shutdown = %v, want close 1001", err)
+	}
+	// New connections are refused
+	if _, err := http.Get(server.URL); err == nil {
+		t.Errorf("server still accepts connections")
+	}
+}
+
+func TestReceiverClose(t *testing.T) {
+	r, eventChan := newTestReceiver(10)
+	pipeline := make(chan common.Event, 10)
+	r.pipeline = &pipeline
+	r.Receive()
+
+	eventChan <- common.Event{Type: "a", Payload: common.M{}}
+	eventChan <- common.Event{Type: "b", Payload: common.M{}}
+	r.Close()
+
+	var forwarded []string
+	for event := range pipeline {
+		forwarded = append(forwarded, event.Type)
+	}
+	if len(forwarded) != 2 || forwarded[0] != "a" || forwarded[1] != "b" {
+		t.Errorf("forwarded = %v", forwarded)
+	}
+}
+
+func TestUpgradeCountsBeforeHijack(t *testing.T) {
+	r, _ := newTestReceiver(10)
+	r.done = make(chan struct{})
+
+	// A failed upgrade does not keep Shutdown waiting
+	rec := httptest.NewRecorder()
+	if _, _, err := r.upgrade(rec, httptest.NewRequest(http.MethodGet, "/ws", nil)); err == nil {
+		t.Fatalf("upgrade of a plain request should fail")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := r.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+
+	// Once shutdown started no connection is taken, so none can send after Close
+	rec = httptest.NewRecorder()
+	if _, _, err := r.upgrade(rec, httptest.NewRequest(http.MethodGet, "/ws", nil)); err != errShuttingDown {
+		t.Errorf("upgrade after shutdown = %v, want errShuttingDown", err)
+	}
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", rec.Code)
+	}
+}
@@ -0,0 +1,101 @@
+package receiver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"clutch/services/subscribe"
+
+	"github.com/gorilla/websocket"
+)
+
+// Subscribers that can not take a message within this time are disconnected
+const subscribeWriteTimeout = 10 * time.Second
+
+// Subscribe reply statuses
+const (
+	SubscribedStatus = "subscribed"
+	ErrorStatus      = "error"
+)
+
+// SubscribeReply answers the filter sent on /subscribe
+type SubscribeReply struct {
+	Status string `json:"status"`
+	ID     string `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// visibleTypes limits a subscription to the event types its API key may publish
+func visibleTypes(ctx context.Context) func(eventType string) bool {
+	key, ok := ctx.Value(apiKeyContextKey{}).(*apiKey)
+	if !ok {
+		return nil
+	}
+	return key.allows
+}
+
+// HandleSubscribe streams the events passing through the pipeline. The first text
+// frame is the filter, matching events are then pushed as JSON until the client
+// leaves or falls too far behind.
+func (r *Receiver) HandleSubscribe(w http.ResponseWriter, req *http.Request) {
+	conn, release, err := r.upgrade(w, req)
+	if err != nil {
+		fmt.Println("Failed to upgrade connection:", err)
+		return
+	}
+	defer conn.Close()
+	defer release()
+
+	var subscription *subscribe.Subscription
+	for subscription == nil {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		filter, err := subscribe.ParseFilter(message)
+		if err != nil {
+			fmt.Println("Rejected subscription filter (HandleSubscribe):", err)
+			if err := conn.WriteJSON(SubscribeReply{Status: ErrorStatus, Error: err.Error()}); err != nil {
+				return
+			}
+			continue
+		}
+		subscription = subscribe.Subscribe(filter, visibleTypes(req.Context()))
+	}
+	defer subscription.Close()
+	fmt.Printf("Subscription %s started from %s\n", subscription.ID, req.RemoteAddr)
+
+	if err := conn.WriteJSON(SubscribeReply{Status: SubscribedStatus, ID: subscription.ID}); err != nil {
+		return
+	}
+
+	// Nothing more is expected from the client, reading only notices it leaving
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				subscription.Close()
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case message := <-subscription.Events:
+			conn.SetWriteDeadline(time.Now().Add(subscribeWriteTimeout))
+			if err := conn.WriteJSON(message); err != nil {
+				fmt.Printf("Error writing to subscription %s (HandleSubscribe): %v\n", subscription.ID, err)
+				return
+			}
+		case <-subscription.Done():
+			if err := subscription.Err(); err == subscribe.ErrSlowSubscriber {
+				closeMessage := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error())
+				conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+			}
+			fmt.Printf("Subscription %s ended: %v\n", subscription.ID, subscription.Err())
+			return
+		}
+	}
+}
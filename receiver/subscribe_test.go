@@ -0,0 +1,52 @@
+package receiver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"clutch/common"
+	"clutch/services/subscribe"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHandleSubscribe(t *testing.T) {
+	r, _ := newAuthReceiver(t, 1)
+	server := httptest.NewServer(r.requireAPIKey(r.HandleSubscribe))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/subscribe", http.Header{"X-API-Key": {"sensor-key"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Bad filters can be corrected on the same connection
+	var reply SubscribeReply
+	for _, filter := range []string{`{"streams":["cooked"]}`, `{"where":[{"field":"machine_id","value":"4"}]}`} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(filter)); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reply.Status != SubscribedStatus || reply.ID == "" {
+		t.Fatalf("reply = %+v", reply)
+	}
+
+	// The key only publishes sensor_* events, so it only sees those
+	subscribe.Publish(subscribe.RawStream, "billing", common.Event{Type: "billing", Payload: common.M{"machine_id": "4"}})
+	subscribe.Publish(subscribe.RawStream, "sensor_a", common.Event{Type: "sensor_a", Payload: common.M{"machine_id": "5"}})
+	subscribe.Publish(subscribe.RawStream, "sensor_a", common.Event{Type: "sensor_a", Payload: common.M{"machine_id": "4"}})
+
+	var message subscribe.Message
+	if err := conn.ReadJSON(&message); err != nil {
+		t.Fatal(err)
+	}
+	if message.Type != "sensor_a" || message.Stream != subscribe.RawStream || string(message.Payload) != `{"machine_id":"4"}` {
+		t.Errorf("message = %+v", message)
+	}
+}
@@ -0,0 +1,406 @@
+package receiver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"clutch/common"
+)
+
+const (
+	defaultSyslogType = "syslog"
+	// Largest syslog message accepted over UDP or TCP
+	maxSyslogMessageSize = 64 * 1024
+)
+
+var (
+	errSyslogPriority = errors.New("missing or invalid syslog priority")
+	errSyslogHeader   = errors.New("truncated syslog header")
+	errSyslogSD       = errors.New("invalid structured data")
+	errSyslogTooLong  = fmt.Errorf("syslog message exceeds %d bytes", maxSyslogMessageSize)
+
+	severityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+)
+
+// SyslogReceiver accepts RFC 5424 and RFC 3164 messages over UDP and TCP
+type SyslogReceiver struct {
+	cfg       *common.SyslogConfig
+	eventChan *chan common.Event
+	udp       net.PacketConn
+	tcp       net.Listener
+	wg        sync.WaitGroup
+	// Open TCP connections, closed by Close
+	conns      map[net.Conn]struct{}
+	connsMutex sync.Mutex
+	closed     bool
+	eventLog
+}
+
+func NewSyslogReceiver(cfg *common.SyslogConfig) *SyslogReceiver {
+	return &SyslogReceiver{
+		cfg:       cfg,
+		eventChan: &common.EventChan,
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// Start opens the configured listeners and begins reading messages
+func (s *SyslogReceiver) Start() error {
+	if s.cfg.UDP != "" {
+		conn, err := net.ListenPacket("udp", s.cfg.UDP)
+		if err != nil {
+			return fmt.Errorf("error listening for syslog on udp %s: %w", s.cfg.UDP, err)
+		}
+		s.udp = conn
+		fmt.Println("Syslog listening on udp", conn.LocalAddr())
+		s.wg.Add(1)
+		go s.serveUDP()
+	}
+	if s.cfg.TCP != "" {
+		listener, err := net.Listen("tcp", s.cfg.TCP)
+		if err != nil {
+			s.Close()
+			return fmt.Errorf("error listening for syslog on tcp %s: %w", s.cfg.TCP, err)
+		}
+		s.tcp = listener
+		fmt.Println("Syslog listening on tcp", listener.Addr())
+		s.wg.Add(1)
+		go s.serveTCP()
+	}
+	return nil
+}
+
+// Close stops the listeners and the open connections and waits for the readers to return
+func (s *SyslogReceiver) Close() error {
+	var err error
+	if s.udp != nil {
+		err = s.udp.Close()
+	}
+	if s.tcp != nil {
+		if tcpErr := s.tcp.Close(); err == nil {
+			err = tcpErr
+		}
+	}
+	s.connsMutex.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.connsMutex.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *SyslogReceiver) eventType() string {
+	if s.cfg.Type != "" {
+		return s.cfg.Type
+	}
+	return defaultSyslogType
+}
+
+func (s *SyslogReceiver) handleMessage(message []byte, remoteAddr string) {
+	message = bytes.TrimRight(message, "\r\n\x00")
+	if len(message) == 0 {
+		return
+	}
+	payload, err := ParseSyslog(message)
+	if err != nil {
+		fmt.Printf("Error parsing syslog message %q: %v\n", message, err)
+		return
+	}
+	expandSecurityEvent(payload)
+	event := common.Event{Type: s.eventType(), Payload: payload, Meta: common.NewEventMeta("syslog", remoteAddr)}
+	if err := s.logEvent(&event); err != nil {
+		fmt.Println("Error logging syslog message:", err)
+	}
+	*s.eventChan <- event
+}
+
+func (s *SyslogReceiver) serveUDP() {
+	defer s.wg.Done()
+	buf := make([]byte, maxSyslogMessageSize)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				fmt.Println("Error reading syslog datagram:", err)
+			}
+			return
+		}
+		s.handleMessage(buf[:n], addr.String())
+	}
+}
+
+func (s *SyslogReceiver) serveTCP() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				fmt.Println("Error accepting syslog connection:", err)
+			}
+			return
+		}
+		s.connsMutex.Lock()
+		if s.closed {
+			s.connsMutex.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.connsMutex.Unlock()
+		s.wg.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+func (s *SyslogReceiver) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.connsMutex.Lock()
+		delete(s.conns, conn)
+		s.connsMutex.Unlock()
+		conn.Close()
+	}()
+	reader := bufio.NewReader(conn)
+	for {
+		frame, err := readSyslogFrame(reader)
+		if len(frame) > 0 {
+			s.handleMessage(frame, conn.RemoteAddr().String())
+		}
+		if err != nil {
+			if err != io.EOF {
+				fmt.Println("Error reading syslog stream from", conn.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+}
+
+// expandSecurityEvent adds the fields of a CEF or LEEF message to the syslog
+// payload. The syslog fields are kept, so the raw message stays in msg.
+func expandSecurityEvent(payload common.M) {
+	msg, _ := payload["msg"].(string)
+	fields, err := common.ParseSecurityEvent(msg)
+	if err == common.ErrNotSecurityEvent {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error parsing security event %q: %v\n", msg, err)
+		return
+	}
+	common.AddSecurityFields(payload, fields)
+}
+
+// readSyslogFrame reads one message using octet counting when the frame starts
+// with a length (RFC 6587), falling back to newline delimited framing
+func readSyslogFrame(reader *bufio.Reader) ([]byte, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] >= '1' && first[0] <= '9' {
+		// A length of maxSyslogMessageSize has at most this many digits and a space
+		length, err := readUntil(reader, ' ', len(strconv.Itoa(maxSyslogMessageSize))+1)
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(string(length)))
+		if err != nil || n > maxSyslogMessageSize {
+			return nil, fmt.Errorf("invalid syslog frame length %q", length)
+		}
+		frame := make([]byte, n)
+		_, err = io.ReadFull(reader, frame)
+		return frame, err
+	}
+
+	return readUntil(reader, '\n', maxSyslogMessageSize)
+}
+
+// readUntil reads up to and including delim, giving up as soon as more than limit
+// bytes arrive without it
+func readUntil(reader *bufio.Reader, delim byte, limit int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice(delim)
+		if len(line)+len(chunk) > limit {
+			return nil, errSyslogTooLong
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+// ParseSyslog turns an RFC 5424 or RFC 3164 message into an event payload
+func ParseSyslog(message []byte) (common.M, error) {
+	msg := string(message)
+	if !strings.HasPrefix(msg, "<") {
+		return nil, errSyslogPriority
+	}
+	end := strings.IndexByte(msg, '>')
+	if end < 2 || end > 4 {
+		return nil, errSyslogPriority
+	}
+	priority, err := strconv.Atoi(msg[1:end])
+	if err != nil || priority > 191 {
+		return nil, errSyslogPriority
+	}
+
+	payload := common.M{
+		"priority":      priority,
+		"facility":      priority / 8,
+		"severity":      priority % 8,
+		"severity_name": severityNames[priority%8],
+	}
+	rest := msg[end+1:]
+
+	// RFC 5424 messages carry a version right after the priority
+	if len(rest) > 1 && rest[0] >= '1' && rest[0] <= '9' && rest[1] == ' ' {
+		return payload, parseRFC5424(payload, rest)
+	}
+	parseRFC3164(payload, rest)
+	return payload, nil
+}
+
+func nilValue(field string) string {
+	if field == "-" {
+		return ""
+	}
+	return field
+}
+
+// parseRFC5424 parses VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+func parseRFC5424(payload common.M, rest string) error {
+	fields := strings.SplitN(rest, " ", 7)
+	if len(fields) < 7 {
+		return errSyslogHeader
+	}
+	version, _ := strconv.Atoi(fields[0])
+	payload["version"] = version
+	if timestamp := nilValue(fields[1]); timestamp != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			return fmt.Errorf("invalid timestamp %q: %w", timestamp, err)
+		}
+		payload["timestamp"] = parsed.Format(time.RFC3339Nano)
+	}
+	payload["host"] = nilValue(fields[2])
+	payload["app"] = nilValue(fields[3])
+	payload["proc_id"] = nilValue(fields[4])
+	payload["msg_id"] = nilValue(fields[5])
+
+	sd, msg, err := parseStructuredData(fields[6])
+	if err != nil {
+		return err
+	}
+	if len(sd) > 0 {
+		payload["structured_data"] = sd
+	}
+	payload["msg"] = strings.TrimPrefix(msg, "\ufeff")
+	return nil
+}
+
+// parseStructuredData reads [id key="value" ...] elements and returns the remaining message
+func parseStructuredData(rest string) (map[string]interface{}, string, error) {
+	sd := make(map[string]interface{})
+	if strings.HasPrefix(rest, "-") {
+		return sd, strings.TrimPrefix(strings.TrimPrefix(rest, "-"), " "), nil
+	}
+
+	i := 0
+	for i < len(rest) && rest[i] == '[' {
+		i++
+		start := i
+		for i < len(rest) && rest[i] != ' ' && rest[i] != ']' {
+			i++
+		}
+		if i >= len(rest) {
+			return nil, "", errSyslogSD
+		}
+		params := make(map[string]interface{})
+		sd[rest[start:i]] = params
+
+		for i < len(rest) && rest[i] == ' ' {
+			i++
+			start = i
+			for i < len(rest) && rest[i] != '=' {
+				i++
+			}
+			if i+1 >= len(rest) || rest[i+1] != '"' {
+				return nil, "", errSyslogSD
+			}
+			name := rest[start:i]
+			i += 2
+
+			var value strings.Builder
+			for i < len(rest) && rest[i] != '"' {
+				if rest[i] == '\\' && i+1 < len(rest) && strings.IndexByte(`"\]`, rest[i+1]) >= 0 {
+					i++
+				}
+				value.WriteByte(rest[i])
+				i++
+			}
+			if i >= len(rest) {
+				return nil, "", errSyslogSD
+			}
+			params[name] = value.String()
+			i++
+		}
+		if i >= len(rest) || rest[i] != ']' {
+			return nil, "", errSyslogSD
+		}
+		i++
+	}
+	return sd, strings.TrimPrefix(rest[i:], " "), nil
+}
+
+// parseRFC3164 parses the legacy "Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG" format as far as it is present
+func parseRFC3164(payload common.M, rest string) {
+	const stamp = "Jan _2 15:04:05"
+	if len(rest) >= len(stamp) {
+		if parsed, err := time.ParseInLocation(stamp, rest[:len(stamp)], time.Local); err == nil {
+			// The legacy format has no year, assume the message is from the last twelve months
+			now := time.Now()
+			parsed = parsed.AddDate(now.Year(), 0, 0)
+			if parsed.After(now.Add(24 * time.Hour)) {
+				parsed = parsed.AddDate(-1, 0, 0)
+			}
+			payload["timestamp"] = parsed.Format(time.RFC3339Nano)
+			rest = strings.TrimPrefix(rest[len(stamp):], " ")
+
+			if host, remainder, found := strings.Cut(rest, " "); found {
+				payload["host"] = host
+				rest = remainder
+			}
+		}
+	}
+
+	// The tag ends at the first character that is not alphanumeric, usually "app[pid]:".
+	// Devices sending CEF or LEEF often leave the tag out.
+	tagEnd := strings.IndexAny(rest, ":[ ")
+	if strings.HasPrefix(rest, "CEF:") || strings.HasPrefix(rest, "LEEF:") {
+		tagEnd = -1
+	}
+	if tagEnd > 0 && tagEnd <= 48 && rest[tagEnd] != ' ' {
+		payload["app"] = rest[:tagEnd]
+		rest = rest[tagEnd:]
+		if strings.HasPrefix(rest, "[") {
+			if pidEnd := strings.IndexByte(rest, ']'); pidEnd > 0 {
+				payload["proc_id"] = rest[1:pidEnd]
+				rest = rest[pidEnd+1:]
+			}
+		}
+		rest = strings.TrimPrefix(rest, ":")
+	}
+	payload["msg"] = strings.TrimPrefix(rest, " ")
+}
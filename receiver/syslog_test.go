@@ -0,0 +1,200 @@
+package receiver
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"clutch/common"
+)
+
+func TestParseSyslogRFC5424(t *testing.T) {
+	message := `<165>1 2024-01-01T10:00:00.003Z gateway-7 tractor 8710 ID47 [exampleSDID@32473 iut="3" eventSource="Application \"x\""][meta sequenceId="1"] engine temperature high`
+	payload, err := ParseSyslog([]byte(message))
+	if err != nil {
+		t.Fatalf("ParseSyslog() error = %v", err)
+	}
+
+	want := common.M{
+		"priority":      165,
+		"facility":      20,
+		"severity":      5,
+		"severity_name": "notice",
+		"version":       1,
+		"timestamp":     "2024-01-01T10:00:00.003Z",
+		"host":          "gateway-7",
+		"app":           "tractor",
+		"proc_id":       "8710",
+		"msg_id":        "ID47",
+		"structured_data": map[string]interface{}{
+			"exampleSDID@32473": map[string]interface{}{"iut": "3", "eventSource": `Application "x"`},
+			"meta":              map[string]interface{}{"sequenceId": "1"},
+		},
+		"msg": "engine temperature high",
+	}
+	if !reflect.DeepEqual(payload, want) {
+		t.Errorf("ParseSyslog() = %v, want %v", payload, want)
+	}
+}
+
+func TestParseSyslogRFC5424NilValues(t *testing.T) {
+	payload, err := ParseSyslog([]byte("<14>1 - - - - - -"))
+	if err != nil {
+		t.Fatalf("ParseSyslog() error = %v", err)
+	}
+	if payload["host"] != "" || payload["msg"] != "" || payload["structured_data"] != nil {
+		t.Errorf("ParseSyslog() = %v, want empty fields", payload)
+	}
+}
+
+func TestParseSyslogRFC3164(t *testing.T) {
+	payload, err := ParseSyslog([]byte("<34>Oct 11 22:14:15 harvester-2 combine[412]: grain tank full"))
+	if err != nil {
+		t.Fatalf("ParseSyslog() error = %v", err)
+	}
+	if payload["facility"] != 4 || payload["severity"] != 2 {
+		t.Errorf("facility/severity = %v/%v, want 4/2", payload["facility"], payload["severity"])
+	}
+	if payload["host"] != "harvester-2" || payload["app"] != "combine" || payload["proc_id"] != "412" {
+		t.Errorf("host/app/proc_id = %v/%v/%v", payload["host"], payload["app"], payload["proc_id"])
+	}
+	if payload["msg"] != "grain tank full" {
+		t.Errorf("msg = %q, want %q", payload["msg"], "grain tank full")
+	}
+	if _, err := time.Parse(time.RFC3339Nano, payload["timestamp"].(string)); err != nil {
+		t.Errorf("timestamp = %v, want RFC 3339", payload["timestamp"])
+	}
+}
+
+func TestSyslogSecurityEvents(t *testing.T) {
+	eventChan := make(chan common.Event, 2)
+	s := NewSyslogReceiver(&common.SyslogConfig{})
+	s.eventChan = &eventChan
+
+	s.handleMessage([]byte(`<134>Oct 11 22:14:15 fw01 CEF:0|Security|threatmanager|1.0|100|worm successfully stopped|10|src=10.0.0.1 dst=2.1.2.2 spt=1232 msg=Detected a threat. No action needed.`), "10.0.0.9:514")
+	s.handleMessage([]byte("<134>1 2024-01-01T10:00:00Z ids01 - - - - LEEF:1.0|Lancope|StealthWatch|1.0|41|src=10.0.1.8\tdst=10.0.0.5"), "10.0.0.9:514")
+
+	cef := <-eventChan
+	if cef.Meta.Listener != "syslog" || cef.Meta.RemoteAddr != "10.0.0.9:514" || cef.Meta.ID == "" {
+		t.Errorf("meta = %+v", cef.Meta)
+	}
+	if cef.Payload["host"] != "fw01" || cef.Payload["device_vendor"] != "Security" || cef.Payload["src"] != "10.0.0.1" {
+		t.Errorf("CEF payload = %v", cef.Payload)
+	}
+	// Both headers set severity and the message sets msg, the syslog ones are kept
+	if cef.Payload["severity"] != 6 || cef.Payload["msg"] != `CEF:0|Security|threatmanager|1.0|100|worm successfully stopped|10|src=10.0.0.1 dst=2.1.2.2 spt=1232 msg=Detected a threat. No action needed.` {
+		t.Errorf("severity/msg = %v/%q", cef.Payload["severity"], cef.Payload["msg"])
+	}
+	leef := <-eventChan
+	if leef.Payload["host"] != "ids01" || leef.Payload["event_id"] != "41" || leef.Payload["dst"] != "10.0.0.5" {
+		t.Errorf("LEEF payload = %v", leef.Payload)
+	}
+}
+
+func TestParseSyslogInvalid(t *testing.T) {
+	for _, message := range []string{"no priority", "<999>1 - - - - - -", "<14>1 2024-01-01T10:00:00Z host", `<14>1 - - - - - [broken`} {
+		if _, err := ParseSyslog([]byte(message)); err == nil {
+			t.Errorf("ParseSyslog(%q) expected an error", message)
+		}
+	}
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	stream := "21 <14>1 - - - - - - one<14>plain two\n"
+	reader := bufio.NewReader(strings.NewReader(stream))
+
+	for _, want := range []string{"<14>1 - - - - - - one", "<14>plain two\n"} {
+		frame, err := readSyslogFrame(reader)
+		if err != nil {
+			t.Fatalf("readSyslogFrame() error = %v", err)
+		}
+		if string(frame) != want {
+			t.Errorf("frame = %q, want %q", frame, want)
+		}
+	}
+}
+
+func TestReadSyslogFrameTooLong(t *testing.T) {
+	for _, stream := range []string{
+		strings.Repeat("a", maxSyslogMessageSize+1) + "\n",
+		"123456789012 <14>too long a length",
+	} {
+		// The stream never ends, the reader must give up on its own
+		reader := bufio.NewReader(io.MultiReader(strings.NewReader(stream), neverEnding('a')))
+		if _, err := readSyslogFrame(reader); err != errSyslogTooLong {
+			t.Errorf("readSyslogFrame(%.20q...) error = %v, want %v", stream, err, errSyslogTooLong)
+		}
+	}
+}
+
+// neverEnding is a stream of the same byte without a delimiter
+type neverEnding byte
+
+func (b neverEnding) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(b)
+	}
+	return len(p), nil
+}
+
+func TestSyslogReceiverUDP(t *testing.T) {
+	eventChan := make(chan common.Event, 1)
+	s := &SyslogReceiver{
+		cfg:       &common.SyslogConfig{UDP: "127.0.0.1:0", Type: "gateway_logs"},
+		eventChan: &eventChan,
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("udp", s.udp.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("<13>1 - host app - - - hello\n"))
+
+	select {
+	case event := <-eventChan:
+		if event.Type != "gateway_logs" || event.Payload["msg"] != "hello" {
+			t.Errorf("event = %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+	}
+}
+
+func TestSyslogReceiverCloseTCP(t *testing.T) {
+	eventChan := make(chan common.Event, 1)
+	s := NewSyslogReceiver(&common.SyslogConfig{TCP: "127.0.0.1:0"})
+	s.eventChan = &eventChan
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	conn, err := net.Dial("tcp", s.tcp.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("<13>1 - host app - - - hello\n"))
+	<-eventChan
+
+	// Close does not wait for the client to hang up
+	closed := make(chan error)
+	go func() { closed <- s.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close() is waiting for the open connection")
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("connection still open after Close()")
+	}
+}
@@ -0,0 +1,385 @@
+package receiver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"clutch/common"
+)
+
+const (
+	defaultTailType         = "file"
+	defaultTailPollInterval = time.Second
+	// Files are recognised across renames by a hash of their first bytes
+	fingerprintSize = 256
+	maxTailLineSize = 1 << 20
+)
+
+// tailCheckpoint is the persisted read position of a single file
+type tailCheckpoint struct {
+	Offset          int64  `json:"offset"`
+	Fingerprint     string `json:"fingerprint"`
+	FingerprintSize int64  `json:"fingerprint_size"`
+}
+
+type tailedFile struct {
+	path    string
+	file    *os.File
+	reader  *bufio.Reader
+	offset  int64 // end of the last complete line
+	partial []byte
+	discard bool // inside a line that was too long to keep
+	cp      tailCheckpoint
+}
+
+// rotatedFile remembers where a rotated file was left in case a glob matches its new name
+type rotatedFile struct {
+	info   os.FileInfo
+	offset int64
+}
+
+// FileTailer follows the files matching a set of globs and emits every line as an event
+type FileTailer struct {
+	cfg         *common.TailConfig
+	eventChan   *chan common.Event
+	files       map[string]*tailedFile
+	draining    []*tailedFile
+	rotated     []rotatedFile
+	checkpoints map[string]tailCheckpoint
+	done        chan struct{}
+	wg          sync.WaitGroup
+	eventLog
+}
+
+func NewFileTailer(cfg *common.TailConfig) *FileTailer {
+	return &FileTailer{
+		cfg:         cfg,
+		eventChan:   &common.EventChan,
+		files:       make(map[string]*tailedFile),
+		checkpoints: make(map[string]tailCheckpoint),
+		done:        make(chan struct{}),
+	}
+}
+
+func (t *FileTailer) eventType() string {
+	if t.cfg.Type != "" {
+		return t.cfg.Type
+	}
+	return defaultTailType
+}
+
+func (t *FileTailer) checkpointPath() string {
+	if t.cfg.Checkpoint != "" {
+		return t.cfg.Checkpoint
+	}
+	return fmt.Sprintf(".clutch_tail_%s.json", t.eventType())
+}
+
+// Start loads the checkpoint file and polls the globs until Close is called
+func (t *FileTailer) Start() error {
+	if err := t.loadCheckpoints(); err != nil {
+		return err
+	}
+	interval := t.cfg.PollInterval
+	if interval <= 0 {
+		interval = defaultTailPollInterval
+	}
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			t.poll()
+			select {
+			case <-ticker.C:
+			case <-t.done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops polling, closes the files and writes the final checkpoint
+func (t *FileTailer) Close() error {
+	close(t.done)
+	t.wg.Wait()
+	for _, f := range t.draining {
+		f.file.Close()
+	}
+	t.draining = nil
+	for path, f := range t.files {
+		f.file.Close()
+		delete(t.files, path)
+	}
+	return t.saveCheckpoints()
+}
+
+func (t *FileTailer) loadCheckpoints() error {
+	data, err := os.ReadFile(t.checkpointPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading tail checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, &t.checkpoints); err != nil {
+		return fmt.Errorf("error decoding tail checkpoint: %w", err)
+	}
+	return nil
+}
+
+// saveCheckpoints writes the checkpoint file atomically
+func (t *FileTailer) saveCheckpoints() error {
+	for path, f := range t.files {
+		t.checkpoints[path] = f.checkpoint()
+	}
+	data, err := json.MarshalIndent(t.checkpoints, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := t.checkpointPath()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("error writing tail checkpoint: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// fingerprint hashes the first bytes of a file, up to size bytes
+func fingerprint(file *os.File, size int64) (string, int64, error) {
+	buf := make([]byte, size)
+	n, err := file.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return "", 0, err
+	}
+	sum := sha256.Sum256(buf[:n])
+	return hex.EncodeToString(sum[:]), int64(n), nil
+}
+
+func (f *tailedFile) checkpoint() tailCheckpoint {
+	// Widen the fingerprint as small files grow so renamed files stay recognisable
+	if f.cp.FingerprintSize < fingerprintSize {
+		if sum, n, err := fingerprint(f.file, fingerprintSize); err == nil {
+			f.cp.Fingerprint, f.cp.FingerprintSize = sum, n
+		}
+	}
+	f.cp.Offset = f.offset
+	return f.cp
+}
+
+// matches reports whether the file starts with the bytes a checkpoint was taken from
+func matches(file *os.File, cp tailCheckpoint) bool {
+	sum, n, err := fingerprint(file, cp.FingerprintSize)
+	return err == nil && n == cp.FingerprintSize && sum == cp.Fingerprint
+}
+
+// resumeOffset finds where to continue reading a newly opened file. Files that
+// were just rotated under a name the globs also match continue where they were
+// left. A checkpoint for the same path is used when the content still matches,
+// otherwise one taken under another name covers files rotated while Clutch was down.
+func (t *FileTailer) resumeOffset(path string, file *os.File, info os.FileInfo) int64 {
+	for _, rotated := range t.rotated {
+		if os.SameFile(info, rotated.info) {
+			return rotated.offset
+		}
+	}
+
+	size := info.Size()
+	if cp, ok := t.checkpoints[path]; ok && cp.Offset <= size && matches(file, cp) {
+		return cp.Offset
+	}
+	for other, cp := range t.checkpoints {
+		if other == path || cp.FingerprintSize == 0 || cp.Offset > size {
+			continue
+		}
+		if matches(file, cp) {
+			fmt.Printf("Tail resuming %s from the checkpoint of %s\n", path, other)
+			delete(t.checkpoints, other)
+			return cp.Offset
+		}
+	}
+	return 0
+}
+
+// isOpen reports whether a file is already followed under another name. That
+// happens right after a rotation, the next poll picks it up under its new name.
+func (t *FileTailer) isOpen(info os.FileInfo) bool {
+	for _, f := range t.files {
+		if openInfo, err := f.file.Stat(); err == nil && os.SameFile(info, openInfo) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *FileTailer) open(path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Println("Error opening tailed file:", err)
+		return
+	}
+	info, err := file.Stat()
+	if err != nil || info.IsDir() || t.isOpen(info) {
+		file.Close()
+		return
+	}
+
+	offset := t.resumeOffset(path, file, info)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		fmt.Println("Error seeking tailed file:", err)
+		file.Close()
+		return
+	}
+	sum, n, _ := fingerprint(file, fingerprintSize)
+	fmt.Printf("Tailing %s from offset %d\n", path, offset)
+	t.files[path] = &tailedFile{
+		path:   path,
+		file:   file,
+		reader: bufio.NewReader(file),
+		offset: offset,
+		cp:     tailCheckpoint{Fingerprint: sum, FingerprintSize: n},
+	}
+}
+
+// poll picks up new files, reads new lines and handles truncation and rotation
+func (t *FileTailer) poll() {
+	// Rotated files get one more read in case the writer had not reopened yet
+	t.rotated = nil
+	for _, f := range t.draining {
+		t.readLines(f)
+		if info, err := f.file.Stat(); err == nil {
+			t.rotated = append(t.rotated, rotatedFile{info: info, offset: f.offset})
+		}
+		f.file.Close()
+	}
+	t.draining = nil
+
+	for _, pattern := range t.cfg.Paths {
+		matched, err := filepath.Glob(pattern)
+		if err != nil {
+			fmt.Println("Error matching tail path:", err)
+			continue
+		}
+		for _, path := range matched {
+			if _, ok := t.files[path]; !ok {
+				t.open(path)
+			}
+		}
+	}
+
+	for path, f := range t.files {
+		info, err := f.file.Stat()
+		if err != nil {
+			fmt.Println("Error reading tailed file:", err)
+			continue
+		}
+		if info.Size() < f.offset+int64(len(f.partial)) {
+			fmt.Printf("Tailed file %s was truncated, reading from the start\n", path)
+			f.file.Seek(0, io.SeekStart)
+			f.reader.Reset(f.file)
+			f.offset, f.partial = 0, nil
+			f.cp = tailCheckpoint{}
+		}
+
+		t.readLines(f)
+
+		// A different file (or none) at the path means the old one was rotated
+		// away, the next poll opens whatever replaced it from the start
+		current, err := os.Stat(path)
+		if err != nil || !os.SameFile(info, current) {
+			fmt.Printf("Tailed file %s was rotated\n", path)
+			t.draining = append(t.draining, f)
+			delete(t.files, path)
+			delete(t.checkpoints, path)
+		}
+	}
+
+	if err := t.saveCheckpoints(); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// readLines emits the complete lines read since the last poll. Lines are read in
+// bounded chunks, the rest of a line over maxTailLineSize is skipped up to its newline.
+func (t *FileTailer) readLines(f *tailedFile) {
+	for {
+		chunk, err := f.reader.ReadSlice('\n')
+		if f.discard {
+			f.offset += int64(len(chunk))
+		} else if len(f.partial)+len(chunk) > maxTailLineSize {
+			fmt.Printf("Dropping line over %d bytes from %s\n", maxTailLineSize, f.path)
+			f.offset += int64(len(f.partial) + len(chunk))
+			f.partial = nil
+			f.discard = true
+		} else {
+			f.partial = append(f.partial, chunk...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				fmt.Println("Error reading tailed file:", err)
+			}
+			return
+		}
+
+		if f.discard {
+			f.discard = false
+			continue
+		}
+		line := f.partial
+		f.offset += int64(len(line))
+		f.partial = nil
+		t.emit(bytes.TrimRight(line, "\r\n"))
+	}
+}
+
+func (t *FileTailer) emit(line []byte) {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return
+	}
+	payload, err := t.decodeLine(line)
+	if err != nil {
+		fmt.Printf("Error decoding tailed line %q: %v\n", line, err)
+		return
+	}
+	event := common.Event{Type: t.eventType(), Payload: payload, Meta: common.NewEventMeta("tail", "")}
+	// Logged before the offset moves past the line, a crash replays what was queued
+	if err := t.logEvent(&event); err != nil {
+		fmt.Printf("Error logging tailed line from %s: %v\n", t.cfg.Paths, err)
+	}
+	*t.eventChan <- event
+}
+
+// decodeLine keeps JSON objects as the payload and wraps anything else as a message
+func (t *FileTailer) decodeLine(line []byte) (common.M, error) {
+	if t.cfg.Format != "text" {
+		var payload common.M
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		err := decoder.Decode(&payload)
+		if err == nil && payload != nil {
+			return payload, nil
+		}
+		if t.cfg.Format == "json" {
+			if err == nil {
+				err = fmt.Errorf("line is not a JSON object")
+			}
+			return nil, err
+		}
+	}
+	return common.M{"message": string(line)}, nil
+}
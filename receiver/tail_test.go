@@ -0,0 +1,174 @@
+package receiver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"clutch/common"
+)
+
+func newTestTailer(t *testing.T, dir string, pattern string) (*FileTailer, chan common.Event) {
+	t.Helper()
+	eventChan := make(chan common.Event, 100)
+	tailer := NewFileTailer(&common.TailConfig{
+		Paths:      []string{filepath.Join(dir, pattern)},
+		Type:       "device_logs",
+		Checkpoint: filepath.Join(dir, "checkpoint.json"),
+	})
+	tailer.eventChan = &eventChan
+	if err := tailer.loadCheckpoints(); err != nil {
+		t.Fatalf("loadCheckpoints() error = %v", err)
+	}
+	return tailer, eventChan
+}
+
+func appendFile(t *testing.T, path string, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func drain(eventChan chan common.Event) []common.Event {
+	var events []common.Event
+	for len(eventChan) > 0 {
+		events = append(events, <-eventChan)
+	}
+	return events
+}
+
+func messages(events []common.Event) []string {
+	var out []string
+	for _, event := range events {
+		if message, ok := event.Payload["message"].(string); ok {
+			out = append(out, message)
+		} else {
+			out = append(out, "json")
+		}
+	}
+	return out
+}
+
+func TestFileTailerLines(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "device.log")
+	tailer, eventChan := newTestTailer(t, dir, "*.log")
+
+	appendFile(t, path, "{\"machine_id\":4}\nplain line\npartial")
+	tailer.poll()
+	events := drain(eventChan)
+	if len(events) != 2 {
+		t.Fatalf("events = %v, want 2", events)
+	}
+	if events[0].Type != "device_logs" || events[0].Payload["machine_id"] == nil {
+		t.Errorf("json event = %+v", events[0])
+	}
+	if events[1].Payload["message"] != "plain line" {
+		t.Errorf("text event = %+v", events[1])
+	}
+
+	appendFile(t, path, " line\n")
+	tailer.poll()
+	if got := messages(drain(eventChan)); len(got) != 1 || got[0] != "partial line" {
+		t.Errorf("events = %v, want [partial line]", got)
+	}
+}
+
+func TestFileTailerLongLine(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "device.log")
+	tailer, eventChan := newTestTailer(t, dir, "*.log")
+
+	// The long line arrives over two polls, neither keeps more than the limit
+	appendFile(t, path, "before\n"+strings.Repeat("x", maxTailLineSize))
+	tailer.poll()
+	appendFile(t, path, strings.Repeat("x", 10)+"\nafter\n")
+	tailer.poll()
+	if got := messages(drain(eventChan)); !reflect.DeepEqual(got, []string{"before", "after"}) {
+		t.Errorf("events = %v, want [before after]", got)
+	}
+	info, _ := os.Stat(path)
+	if f := tailer.files[path]; f.offset != info.Size() || len(f.partial) != 0 {
+		t.Errorf("offset = %d of %d with %d bytes kept", f.offset, info.Size(), len(f.partial))
+	}
+}
+
+func TestFileTailerCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "device.log")
+	appendFile(t, path, "one\ntwo\n")
+
+	tailer, eventChan := newTestTailer(t, dir, "*.log")
+	tailer.poll()
+	tailer.Close()
+	if got := messages(drain(eventChan)); len(got) != 2 {
+		t.Fatalf("events = %v, want 2", got)
+	}
+
+	appendFile(t, path, "three\n")
+	restarted, eventChan := newTestTailer(t, dir, "*.log")
+	restarted.poll()
+	defer restarted.Close()
+	if got := messages(drain(eventChan)); len(got) != 1 || got[0] != "three" {
+		t.Errorf("events after restart = %v, want [three]", got)
+	}
+}
+
+func TestFileTailerTruncate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "device.log")
+	tailer, eventChan := newTestTailer(t, dir, "*.log")
+	defer tailer.Close()
+
+	appendFile(t, path, "a long first line\n")
+	tailer.poll()
+	drain(eventChan)
+
+	if err := os.WriteFile(path, []byte("new\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tailer.poll()
+	if got := messages(drain(eventChan)); len(got) != 1 || got[0] != "new" {
+		t.Errorf("events after truncate = %v, want [new]", got)
+	}
+}
+
+func TestFileTailerRotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "device.log")
+	tailer, eventChan := newTestTailer(t, dir, "device.log*")
+	defer tailer.Close()
+
+	appendFile(t, path, "before\n")
+	tailer.poll()
+	drain(eventChan)
+
+	// The writer still appends to the old file once after it is renamed
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path+".1", "late\n")
+	appendFile(t, path, "after\n")
+	tailer.poll()
+	tailer.poll()
+	tailer.poll()
+
+	got := messages(drain(eventChan))
+	want := map[string]bool{"late": true, "after": true}
+	if len(got) != len(want) {
+		t.Fatalf("events after rotate = %v, want late and after once", got)
+	}
+	for _, message := range got {
+		if !want[message] {
+			t.Errorf("unexpected event %q", message)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package receiver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"clutch/common"
+)
+
+// identityContextKey stores the verified client certificate identity on the request context
+type identityContextKey struct{}
+
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS12,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig builds the server TLS settings, nil while no certificate is configured
+func newTLSConfig(cfg common.TLSConfig) (*tls.Config, error) {
+	if cfg.Cert == "" && cfg.Key == "" {
+		if cfg.ClientCA != "" {
+			return nil, errors.New("tls: client_ca needs cert and key")
+		}
+		return nil, nil
+	}
+	certificate, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	minVersion, ok := tlsVersions[cfg.MinVersion]
+	if !ok {
+		return nil, fmt.Errorf("tls: unsupported min_version %q, use 1.2 or 1.3", cfg.MinVersion)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   minVersion,
+	}
+
+	if cfg.ClientCA != "" {
+		pem, err := os.ReadFile(cfg.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls: no certificates in %s", cfg.ClientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// certificateIdentity maps the verified client certificate to an identity, its
+// common name or the whole subject when there is none
+func certificateIdentity(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	subject := state.VerifiedChains[0][0].Subject
+	if subject.CommonName != "" {
+		return subject.CommonName
+	}
+	return subject.String()
+}
+
+// identify puts the identity of a mutual TLS client on the request context
+func identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if identity := certificateIdentity(req.TLS); identity != "" {
+			req = req.WithContext(context.WithValue(req.Context(), identityContextKey{}, identity))
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,189 @@
+package receiver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"clutch/common"
+
+	"github.com/gorilla/websocket"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// issue creates a certificate signed by parent, or a self signed CA when parent is nil
+func issue(t *testing.T, subject pkix.Name, parent *testCert, server bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.ExtKeyUsage = nil
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write saves the certificate and key as PEM files and returns their paths
+func (c *testCert) write(t *testing.T, name string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestMutualTLSIdentity(t *testing.T) {
+	ca := issue(t, pkix.Name{CommonName: "clutch test ca"}, nil, false)
+	serverCert := issue(t, pkix.Name{CommonName: "clutch"}, ca, true)
+	gateway := issue(t, pkix.Name{CommonName: "gateway-7", Organization: []string{"North Field"}}, ca, false)
+	stranger := issue(t, pkix.Name{CommonName: "stranger"}, issue(t, pkix.Name{CommonName: "other ca"}, nil, false), false)
+
+	certPath, keyPath := serverCert.write(t, "server")
+	caPath, _ := ca.write(t, "ca")
+	tlsConfig, err := newTLSConfig(common.TLSConfig{Cert: certPath, Key: keyPath, ClientCA: caPath, MinVersion: "1.3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, eventChan := newTestReceiver(10)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", r.requireAPIKey(r.HandleIngest))
+	mux.HandleFunc("/ws", r.requireAPIKey(r.HandleWebSocket))
+	server := httptest.NewUnstartedServer(identify(mux))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := func(cert *testCert) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: []tls.Certificate{cert.tlsCertificate()},
+		}}}
+	}
+
+	resp, err := client(gateway).Post(server.URL+"/events", "application/json", strings.NewReader(`{"type":"a","payload":{}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("status = %v", resp.StatusCode)
+	}
+	if event := <-eventChan; event.Meta.Identity != "gateway-7" {
+		t.Errorf("identity = %q, want gateway-7", event.Meta.Identity)
+	}
+
+	// wss:// gets the same identity
+	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{gateway.tlsCertificate()}}}
+	conn, _, err := dialer.Dial("wss"+strings.TrimPrefix(server.URL, "https")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"b","payload":{}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if event := <-eventChan; event.Type != "b" || event.Meta.Identity != "gateway-7" {
+		t.Errorf("event = %+v", event)
+	}
+
+	// Certificates from another CA and missing certificates fail the handshake
+	if _, err := client(stranger).Get(server.URL + "/events"); err == nil {
+		t.Errorf("expected a handshake error for an unknown CA")
+	}
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	if _, err := anonymous.Get(server.URL + "/events"); err == nil {
+		t.Errorf("expected a handshake error without a client certificate")
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	ca := issue(t, pkix.Name{CommonName: "clutch test ca"}, nil, false)
+	certPath, keyPath := issue(t, pkix.Name{CommonName: "clutch"}, ca, true).write(t, "server")
+
+	if tlsConfig, err := newTLSConfig(common.TLSConfig{}); tlsConfig != nil || err != nil {
+		t.Errorf("empty config = %v, %v, want TLS off", tlsConfig, err)
+	}
+	tlsConfig, err := newTLSConfig(common.TLSConfig{Cert: certPath, Key: keyPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS12 || tlsConfig.ClientAuth != tls.NoClientCert {
+		t.Errorf("min version = %x, client auth = %v", tlsConfig.MinVersion, tlsConfig.ClientAuth)
+	}
+
+	tests := []struct {
+		name string
+		cfg  common.TLSConfig
+	}{
+		{"client ca without cert", common.TLSConfig{ClientCA: certPath}},
+		{"missing key", common.TLSConfig{Cert: certPath, Key: "missing.key"}},
+		{"old version", common.TLSConfig{Cert: certPath, Key: keyPath, MinVersion: "1.0"}},
+		{"ca without certificates", common.TLSConfig{Cert: certPath, Key: keyPath, ClientCA: keyPath}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newTLSConfig(tt.cfg); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
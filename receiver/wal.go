@@ -0,0 +1,65 @@
+package receiver
+
+import (
+	"fmt"
+
+	"clutch/common"
+	"clutch/wal"
+)
+
+// eventLog writes events to the write-ahead log, the Receiver and the inputs share it
+type eventLog struct {
+	// nil while the write-ahead log is off
+	wal *wal.Log
+}
+
+// UseWAL logs every event before it is acknowledged, or before an input moves its
+// checkpoint past it, and commits it once the pipeline is done with it. Set it before
+// Receive or Start.
+func (l *eventLog) UseWAL(log *wal.Log) {
+	l.wal = log
+}
+
+// Replay queues the events a previous run logged but did not finish, call it after
+// Receive and before the listeners and inputs start
+func (r *Receiver) Replay() error {
+	if r.wal == nil {
+		return nil
+	}
+	replayed, err := r.wal.Replay(func(event common.Event) {
+		*r.eventChan <- event
+	})
+	if replayed > 0 {
+		fmt.Println("Replayed events from the write-ahead log:", replayed)
+	}
+	return err
+}
+
+// logEvent appends the event to the write-ahead log, unless it is off or the event
+// is logged already. Chat questions are not logged, their session is gone after a restart.
+func (l *eventLog) logEvent(event *common.Event) error {
+	if l.wal == nil || event.Meta.Seq != 0 || event.Type == common.ChatEventType {
+		return nil
+	}
+	seq, err := l.wal.Append(*event)
+	if err != nil {
+		return err
+	}
+	event.Meta.Seq = seq
+	return nil
+}
+
+// discard commits a logged event that was never queued, the client was told to send
+// it again or the input reads it again
+func (l *eventLog) discard(event common.Event) {
+	if event.Meta.Seq != 0 {
+		l.wal.Commit(event.Meta.Seq)
+	}
+}
+
+// commitWhenDone gives a logged event the count the pipeline releases as it goes
+func (l *eventLog) commitWhenDone(event *common.Event) {
+	if seq := event.Meta.Seq; seq != 0 {
+		event.Meta.Pending = common.NewPending(func() { l.wal.Commit(seq) })
+	}
+}
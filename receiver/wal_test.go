@@ -0,0 +1,67 @@
+package receiver
+
+import (
+	"context"
+	"testing"
+
+	"clutch/common"
+	"clutch/wal"
+)
+
+func TestWAL(t *testing.T) {
+	cfg := common.WALConfig{Dir: t.TempDir()}
+	log, err := wal.Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, eventChan := newTestReceiver(1)
+	r.UseWAL(log)
+	ctx := context.Background()
+
+	if err := r.enqueue(ctx, common.Event{Type: "a", Payload: common.M{}}); err != nil {
+		t.Fatal(err)
+	}
+	// Rejected events are committed right away, the client sends them again
+	if err := r.enqueue(ctx, common.Event{Type: "b", Payload: common.M{}}); err != errQueueFull {
+		t.Fatalf("enqueue() = %v, want %v", err, errQueueFull)
+	}
+	pipeline := make(chan common.Event, 10)
+	r.pipeline = &pipeline
+	r.Receive()
+	// Inputs log their events before they send them, and before their checkpoint moves
+	syslog := NewSyslogReceiver(&common.SyslogConfig{})
+	syslog.eventChan = &eventChan
+	syslog.UseWAL(log)
+	syslog.handleMessage([]byte("<14>Oct 11 22:14:15 fw01 c"), "10.0.0.9:514")
+	tailer := NewFileTailer(&common.TailConfig{})
+	tailer.eventChan = &eventChan
+	tailer.UseWAL(log)
+	tailer.emit([]byte("d"))
+	r.Close()
+
+	a, c, d := <-pipeline, <-pipeline, <-pipeline
+	if a.Meta.Seq != 1 || c.Meta.Seq != 3 || d.Meta.Seq != 4 || a.Meta.Pending == nil || c.Meta.Pending == nil {
+		t.Fatalf("forwarded %+v, %+v and %+v", a.Meta, c.Meta, d.Meta)
+	}
+	c.Meta.Pending.Done()
+	d.Meta.Pending.Done()
+	log.Close()
+
+	// Only the event the pipeline did not finish is replayed
+	log, err = wal.Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	r, eventChan = newTestReceiver(10)
+	r.UseWAL(log)
+	if err := r.Replay(); err != nil {
+		t.Fatal(err)
+	}
+	if len(eventChan) != 1 {
+		t.Fatalf("replayed %d events, want 1", len(eventChan))
+	}
+	if event := <-eventChan; event.Type != "a" || event.Meta.Seq != 1 || event.Meta.ID != a.Meta.ID {
+		t.Errorf("replayed %+v", event)
+	}
+}
@@ -11,7 +11,7 @@ TXT_FILE="data/request.txt"
 CONTENT=$(cat "$TXT_FILE" | tr -d '\n' | tr -d '\r')
 
 # Construct the payload
-PAYLOAD="{\"type\":\"chat\",\"payload\":$CONTENT}"
+PAYLOAD="{\"question\":\"$CONTENT\"}"
 # Send the payload to the WebSocket server using websocat
 echo "$PAYLOAD" | websocat "$WS_URL"
 
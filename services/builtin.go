@@ -0,0 +1,11 @@
+package services
+
+// The built-in stages register themselves, services in other packages are enabled
+// the same way by importing them here or in main
+import (
+	_ "clutch/services/mask"
+	_ "clutch/services/parse"
+	_ "clutch/services/storage"
+	_ "clutch/services/subscribe"
+	_ "clutch/services/validate"
+)
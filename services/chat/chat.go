@@ -0,0 +1,158 @@
+package chat
+
+import (
+	"clutch/common"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	defaultMaxDocuments = 5
+	defaultHistoryTurns = 5
+)
+
+var errSessionClosed = errors.New("chat session closed")
+
+// extractDocuments reads the documents out of an Elasticsearch or Qdrant query result
+func extractDocuments(result map[string]interface{}) []map[string]interface{} {
+	docs := []map[string]interface{}{}
+	switch hits := result["hits"].(type) {
+	case []map[string]interface{}:
+		// Qdrant results are already a list of payloads
+		docs = append(docs, hits...)
+	case map[string]interface{}:
+		inner, _ := hits["hits"].([]interface{})
+		for _, hit := range inner {
+			doc, ok := hit.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if source, ok := doc["_source"].(map[string]interface{}); ok {
+				docs = append(docs, source)
+			}
+		}
+	}
+	return docs
+}
+
+func retrieve(cfg *common.Config, index string, question string, limit int) ([]map[string]interface{}, error) {
+	if cfg.Store == nil {
+		return nil, errors.New("no store configured")
+	}
+	query := question
+	if cfg.Database.Type == "elastic" {
+		body, err := json.Marshal(common.M{
+			"size": limit,
+			"query": common.M{
+				"simple_query_string": common.M{"query": question},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		query = string(body)
+	}
+	docs := extractDocuments(cfg.Store.Query(index, query))
+	if len(docs) > limit {
+		docs = docs[:limit]
+	}
+	return docs, nil
+}
+
+// buildContext renders the retrieved documents and the conversation so far for the prompt
+func buildContext(docs []map[string]interface{}, history []common.Turn) string {
+	var b strings.Builder
+	b.WriteString("Documents:\n")
+	for _, doc := range docs {
+		line, err := json.Marshal(doc)
+		if err != nil {
+			continue
+		}
+		b.Write(line)
+		b.WriteString("\n")
+	}
+	if len(history) > 0 {
+		b.WriteString("\nConversation so far:\n")
+		for _, turn := range history {
+			fmt.Fprintf(&b, "User: %s\nAssistant: %s\n", turn.Question, turn.Answer)
+		}
+	}
+	return b.String()
+}
+
+// Answer runs retrieval and the model for a question asked on a session
+func Answer(session *common.Session, question string, index string) common.Reply {
+	cfg := common.GetConfigAddress()
+	chatCfg := cfg.Chat
+	if chatCfg.MaxDocuments <= 0 {
+		chatCfg.MaxDocuments = defaultMaxDocuments
+	}
+	if chatCfg.HistoryTurns <= 0 {
+		chatCfg.HistoryTurns = defaultHistoryTurns
+	}
+	if index == "" {
+		index = chatCfg.Index
+	}
+
+	fail := func(err error) common.Reply {
+		fmt.Println("Error answering chat question:", err)
+		return common.Reply{Type: common.ErrorReply, Question: question, Error: err.Error()}
+	}
+	if cfg.Model == nil {
+		return fail(errors.New("no model configured, add the model service"))
+	}
+	if index == "" {
+		return fail(errors.New("no index to search, set chat.index or send one with the question"))
+	}
+
+	docs, err := retrieve(cfg, index, question, chatCfg.MaxDocuments)
+	if err != nil {
+		return fail(err)
+	}
+
+	if session == nil {
+		answer, err := cfg.Model.QueryWithContext(question, buildContext(docs, nil))
+		if err != nil {
+			return fail(err)
+		}
+		return common.Reply{Type: common.DoneReply, Question: question, Answer: answer, Sources: docs}
+	}
+
+	// Forward tokens as they are generated, stop generating if the client went away
+	answer, err := cfg.Model.QueryWithContextStream(question, buildContext(docs, session.History()), func(token string) error {
+		if !session.Send(common.Reply{Type: common.TokenReply, Token: token}) {
+			return errSessionClosed
+		}
+		return nil
+	})
+	if err != nil {
+		return fail(err)
+	}
+	session.Remember(common.Turn{Question: question, Answer: answer}, chatCfg.HistoryTurns)
+	return common.Reply{Type: common.DoneReply, Question: question, Answer: answer, Sources: docs}
+}
+
+// Chat answers the chat events routed to the chat channel by the distributor
+func Chat(chatChan *chan common.Event) {
+	fmt.Println("Chat service started")
+	for event := range *chatChan {
+		question, _ := event.Payload["question"].(string)
+		index, _ := event.Payload["index"].(string)
+		sessionID, _ := event.Payload["session_id"].(string)
+		if question == "" {
+			fmt.Println("Chat event without a question:", event)
+			continue
+		}
+
+		session, ok := common.GetSession(sessionID)
+		if !ok {
+			// Nobody is waiting on this question, answer it for the logs only
+			reply := Answer(nil, question, index)
+			fmt.Println("Chat answer:", reply.Answer)
+			continue
+		}
+		session.Send(Answer(session, question, index))
+	}
+}
@@ -0,0 +1,123 @@
+package chat
+
+import (
+	"clutch/common"
+	"strings"
+	"testing"
+)
+
+type mockModel struct {
+	contexts []string
+}
+
+func (m *mockModel) GenerateEmbeddings(text string) ([][]float32, error) { return nil, nil }
+func (m *mockModel) QueryWithContext(query string, ctx string) (string, error) {
+	m.contexts = append(m.contexts, ctx)
+	return "machine 4 is in field_1", nil
+}
+func (m *mockModel) QueryWithContextStream(query string, ctx string, onToken func(token string) error) (string, error) {
+	m.contexts = append(m.contexts, ctx)
+	for _, token := range []string{"machine 4 ", "is in ", "field_1"} {
+		if err := onToken(token); err != nil {
+			return "", err
+		}
+	}
+	return "machine 4 is in field_1", nil
+}
+func (m *mockModel) Start()               {}
+func (m *mockModel) GetModelName() string { return "mock_model" }
+
+type mockStore struct {
+	queries []string
+}
+
+func (s *mockStore) InsertDocument(index string, body map[string]interface{}) {}
+func (s *mockStore) Query(index string, query string) map[string]interface{} {
+	s.queries = append(s.queries, query)
+	return map[string]interface{}{
+		"hits": map[string]interface{}{
+			"hits": []interface{}{
+				map[string]interface{}{"_source": map[string]interface{}{"machine_id": "4", "location": "field_1"}},
+			},
+		},
+	}
+}
+func (s *mockStore) DeleteIndex(index string) {}
+func (s *mockStore) Initialize()              {}
+func (s *mockStore) GetResults(searchResult map[string]interface{}) []map[string]interface{} {
+	return nil
+}
+
+func TestExtractDocuments(t *testing.T) {
+	qdrant := map[string]interface{}{
+		"hits": []map[string]interface{}{{"machine_id": "4"}},
+	}
+	if docs := extractDocuments(qdrant); len(docs) != 1 || docs[0]["machine_id"] != "4" {
+		t.Errorf("extractDocuments(qdrant) = %v", docs)
+	}
+	if docs := extractDocuments(nil); len(docs) != 0 {
+		t.Errorf("extractDocuments(nil) = %v, want no documents", docs)
+	}
+}
+
+func TestAnswerKeepsHistory(t *testing.T) {
+	model := &mockModel{}
+	store := &mockStore{}
+	common.SetConfig(common.Config{
+		Database: common.DatabaseConfig{Type: "elastic"},
+		Chat:     common.ChatConfig{Index: "clutch_testing_events", HistoryTurns: 1},
+		Model:    model,
+		Store:    store,
+	})
+	defer common.SetConfig(common.Config{})
+
+	session := common.NewSession("")
+	defer session.Close()
+
+	first := Answer(session, "where is machine 4?", "")
+	if first.Type != common.DoneReply || len(first.Sources) != 1 {
+		t.Fatalf("Answer() = %+v, want a done reply with one source", first)
+	}
+	for _, want := range []string{"machine 4 ", "is in ", "field_1"} {
+		if token := <-session.Replies; token.Type != common.TokenReply || token.Token != want {
+			t.Errorf("token reply = %+v, want %q", token, want)
+		}
+	}
+	if !strings.Contains(store.queries[0], "simple_query_string") {
+		t.Errorf("elastic query = %v, want a simple_query_string query", store.queries[0])
+	}
+
+	Answer(session, "and what is it doing?", "")
+	for len(session.Replies) > 0 {
+		<-session.Replies
+	}
+	if !strings.Contains(model.contexts[1], "User: where is machine 4?") {
+		t.Errorf("second context does not include the first turn: %v", model.contexts[1])
+	}
+	if len(session.History()) != 1 {
+		t.Errorf("history length = %d, want 1", len(session.History()))
+	}
+}
+
+func TestAnswerWithoutModel(t *testing.T) {
+	common.SetConfig(common.Config{})
+	reply := Answer(nil, "anything?", "events")
+	if reply.Type != common.ErrorReply {
+		t.Errorf("Answer() type = %v, want error", reply.Type)
+	}
+}
+
+func TestAnswerStopsWhenSessionCloses(t *testing.T) {
+	common.SetConfig(common.Config{
+		Chat:  common.ChatConfig{Index: "events"},
+		Model: &mockModel{},
+		Store: &mockStore{},
+	})
+	defer common.SetConfig(common.Config{})
+
+	session := common.NewSession("")
+	session.Close()
+	if reply := Answer(session, "anyone there?", ""); reply.Type != common.ErrorReply {
+		t.Errorf("Answer() type = %v, want error", reply.Type)
+	}
+}
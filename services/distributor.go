@@ -2,10 +2,11 @@ package services
 
 import (
 	"clutch/common"
-	"clutch/services/mask"
+	"clutch/services/chat"
 	"clutch/services/model"
-	"clutch/services/storage"
+	"context"
 	"fmt"
+	"log"
 )
 
 func InitializeModel() {
@@ -20,49 +21,39 @@ func InitializeModel() {
 
 func prime() {
 	cfg := common.GetConfig()
-	// Check if mask_storage is in the services
-	mask_storage := false
-	for _, service := range cfg.Services {
-		fmt.Println("Checking service:", service)
-		if service == "mask_storage" {
-			fmt.Println("Starting masked storage service")
-			go storage.StoreMasks(&common.MaskedStorageChan)
-			mask_storage = true
-		}
-	}
-
 	for _, service := range cfg.Services {
 		fmt.Println("Starting service:", service)
 		switch service {
 		case "model":
 			go InitializeModel()
-		case "storage":
-			go storage.Store(&common.StorageChan)
-		case "masking":
-			go mask.Mask(&common.MaskChan, mask_storage)
+		case "chat":
+			go chat.Chat(&common.ChatChan)
 		}
 	}
 }
 
-func Start(pipeline *chan common.Event) {
+// Start runs the pipeline until the pipeline channel is closed and returns once every
+// stage has drained. Cancelling ctx stops the stages without draining.
+func Start(ctx context.Context, pipeline *chan common.Event) {
 	fmt.Println("Distributor started, priming services.")
 	prime()
+	cfg := common.GetConfig()
+	pipelineConfig := cfg.Pipeline
+	if len(pipelineConfig.Stages) == 0 {
+		pipelineConfig = defaultPipeline(cfg.Services)
+	}
+	graph, err := NewGraph(pipelineConfig)
+	if err != nil {
+		log.Fatal("Error building pipeline: ", err)
+	}
+	graph.Start(ctx)
+
+	// Chat questions never get here, the receiver hands them to the chat service
 	for event := range *pipeline {
-		fmt.Println("Distributing event:", event)
-		if event.Type == "chat" {
-			fmt.Println("Chat event:", event)
-			common.ChatChan <- event
-		} else {
-			for _, service := range common.GlobalConfig.Services {
-				switch service {
-				case "storage":
-					common.StorageChan <- event
-				case "masking":
-					common.MaskChan <- event
-				case "synth":
-					common.SynthChan <- event
-				}
-			}
-		}
+		graph.Send(event)
+		event.Meta.Pending.Done()
 	}
+	fmt.Println("Pipeline closed, draining the stages")
+	graph.Close()
+	fmt.Println("Distributor stopped")
 }
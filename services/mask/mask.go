@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+
+	"github.com/google/uuid"
 )
 
 var MaskedEvents []MaskedEvent
@@ -78,7 +80,8 @@ func extractSchemaName(filePath string) string {
 	return strings.TrimSuffix(fileName, filepath.Ext(fileName))
 }
 
-func loadMasks(maskMap *map[string]common.MaskConfig) {
+// LoadMasks reads the schemas/<type>_mask.yaml files into maskMap and the global config
+func LoadMasks(maskMap *map[string]common.MaskConfig) {
 	// Get all file paths in the schemas directory
 	fmt.Println("Loading masks")
 	files, err := filepath.Glob("schemas/*_mask*")
@@ -102,10 +105,12 @@ func loadMasks(maskMap *map[string]common.MaskConfig) {
 	fmt.Println("Loaded masks:", base.Masks)
 }
 
-func Synthesize(event common.Event, maskMap map[string]common.MaskConfig) {
-	fmt.Printf("Synthesizing event %d times.\n", maskMap[event.Type+"_mask"].SynthAmount)
+// Synthesize returns the synthetic copies the mask config of the event type asks for
+func Synthesize(event common.Event, maskMap map[string]common.MaskConfig) []common.Event {
 	mapObject := maskMap[event.Type+"_mask"]
 	synth_amount := mapObject.SynthAmount
+	fmt.Printf("Synthesizing event %d times.\n", synth_amount)
+	var synthesized []common.Event
 	for i := 0; i < synth_amount; i++ {
 		fmt.Println("---------- NEXT SYNTH ----------")
 		maskedEvent := new(MaskedEvent)
@@ -115,7 +120,10 @@ func Synthesize(event common.Event, maskMap map[string]common.MaskConfig) {
 		newEvent := common.Event{
 			Type:    "synthed_" + string(event.Type),
 			Payload: make(common.M),
+			Meta:    event.Meta,
 		}
+		// Every copy is its own document
+		newEvent.Meta.ID = uuid.NewString()
 		for k, v := range event.Payload {
 			newEvent.Payload[k] = v
 		}
@@ -125,11 +133,11 @@ func Synthesize(event common.Event, maskMap map[string]common.MaskConfig) {
 		for _, operation := range mapObject.Operations {
 			maskedEvent.applyOperation(operation)
 		}
-		// Add a unique identifier
 		fmt.Println("Synthesized event:", maskedEvent.MaskedEvent)
 		fmt.Println("---------- Done SYNTH ----------")
-		common.MaskedStorageChan <- maskedEvent.MaskedEvent
+		synthesized = append(synthesized, maskedEvent.MaskedEvent)
 	}
+	return synthesized
 }
 
 func createMaskedEvent(event common.Event, maskMap map[string]common.MaskConfig) MaskedEvent {
@@ -140,30 +148,20 @@ func createMaskedEvent(event common.Event, maskMap map[string]common.MaskConfig)
 		Type:        event.Type,
 	}
 	maskedEvent.MaskedEvent.Type = "masked_" + event.Type
+	// Copy the payload, the raw event is still being stored and streamed
+	maskedEvent.MaskedEvent.Payload = make(common.M, len(event.Payload))
+	for k, v := range event.Payload {
+		maskedEvent.MaskedEvent.Payload[k] = v
+	}
 	for _, operation := range mapObject.Operations {
 		maskedEvent.applyOperation(operation)
 	}
-
-	fmt.Println("About to synth:", mapObject.SynthAmount)
-	if mapObject.SynthAmount > 0 {
-		go Synthesize(maskedEvent.RawEvent, maskMap)
-	}
-
 	return maskedEvent
 }
 
-func Mask(maskChan *chan common.Event, mask_storage bool) {
-	fmt.Println("Masking service started")
-	loadMasks(&MaskMap)
-	base := common.GetConfig()
-	masks := base.Masks
-	fmt.Println("Masks:", masks)
-	for event := range *maskChan {
-		maskedEvent := createMaskedEvent(event, masks)
-		if mask_storage {
-			common.MaskedStorageChan <- maskedEvent.MaskedEvent
-		}
-	}
+// MaskEvent returns the masked copy of an event
+func MaskEvent(event common.Event, maskMap map[string]common.MaskConfig) common.Event {
+	return createMaskedEvent(event, maskMap).MaskedEvent
 }
 
 // Refactor this to just use common.Event as output
@@ -0,0 +1,60 @@
+package mask
+
+import (
+	"clutch/common"
+	"clutch/services/subscribe"
+	"context"
+)
+
+func init() {
+	common.RegisterService("mask", func() common.Service { return &maskService{} })
+	common.RegisterService("synth", func() common.Service { return &synthService{} })
+}
+
+// maskService passes on the masked copy of every event
+type maskService struct {
+	masks map[string]common.MaskConfig
+}
+
+func (s *maskService) Name() string { return "mask" }
+
+func (s *maskService) Init(cfg common.StageConfig) error {
+	LoadMasks(&MaskMap)
+	s.masks = common.GetConfig().Masks
+	return nil
+}
+
+func (s *maskService) Run(ctx context.Context, in <-chan common.Event, out chan<- common.Event) error {
+	return common.ProcessEvents(ctx, in, out, func(event common.Event) []common.Event {
+		masked := MaskEvent(event, s.masks)
+		subscribe.Publish(subscribe.MaskedStream, event.Type, masked)
+		return []common.Event{masked}
+	})
+}
+
+func (s *maskService) Close() error { return nil }
+
+// synthService passes on the synthetic copies of every event
+type synthService struct {
+	masks map[string]common.MaskConfig
+}
+
+func (s *synthService) Name() string { return "synth" }
+
+func (s *synthService) Init(cfg common.StageConfig) error {
+	LoadMasks(&MaskMap)
+	s.masks = common.GetConfig().Masks
+	return nil
+}
+
+func (s *synthService) Run(ctx context.Context, in <-chan common.Event, out chan<- common.Event) error {
+	return common.ProcessEvents(ctx, in, out, func(event common.Event) []common.Event {
+		synthesized := Synthesize(event, s.masks)
+		for _, copy := range synthesized {
+			subscribe.Publish(subscribe.SynthesizedStream, event.Type, copy)
+		}
+		return synthesized
+	})
+}
+
+func (s *synthService) Close() error { return nil }
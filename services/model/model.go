@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/llms/ollama"
 )
 
@@ -107,9 +108,23 @@ func (m *Model) GenerateEmbeddings(text string) ([][]float32, error) {
 	return embeddings, nil
 }
 
-func (m *Model) QueryWithContext(query string, ctx string) (string, error) {
+func (m *Model) buildPrompt(query string, ctx string) string {
 	fmt.Println("Base prompt:", m.BasePrompt)
-	query = strings.Replace(m.BasePrompt, "{context}", ctx, 1)
-	query = strings.Replace(query, "{question}", query, 1)
-	return m.Client.Call(context.Background(), query)
+	prompt := strings.Replace(m.BasePrompt, "{context}", ctx, 1)
+	return strings.Replace(prompt, "{question}", query, 1)
+}
+
+func (m *Model) QueryWithContext(query string, ctx string) (string, error) {
+	return m.Client.Call(context.Background(), m.buildPrompt(query, ctx))
+}
+
+// QueryWithContextStream hands every chunk to onToken as Ollama generates it
+func (m *Model) QueryWithContextStream(query string, ctx string, onToken func(token string) error) (string, error) {
+	return m.Client.Call(
+		context.Background(),
+		m.buildPrompt(query, ctx),
+		llms.WithStreamingFunc(func(_ context.Context, chunk []byte) error {
+			return onToken(string(chunk))
+		}),
+	)
 }
@@ -0,0 +1,164 @@
+package parse
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"clutch/common"
+)
+
+// Nested patterns deeper than this are assumed to reference themselves
+const maxPatternDepth = 32
+
+// %{SYNTAX}, %{SYNTAX:field} or %{SYNTAX:field:type}
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::([\w.@\[\]-]+))?(?::(int|float|string))?\}`)
+
+// capture is a named part of a pattern and the payload field it fills
+type capture struct {
+	path []string
+	kind string
+}
+
+// grok is a pattern expanded into a regexp
+type grok struct {
+	re       *regexp.Regexp
+	captures map[string]capture
+}
+
+type compiler struct {
+	definitions map[string]string
+	captures    map[string]capture
+}
+
+// compileGrok expands the pattern references with the built-in library and the given definitions
+func compileGrok(pattern string, definitions map[string]string) (*grok, error) {
+	c := &compiler{definitions: definitions, captures: make(map[string]capture)}
+	expanded, err := c.expand(pattern, 0)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	// Plain (?P<name>...) groups fill a field of the same name
+	for _, name := range re.SubexpNames() {
+		if _, ok := c.captures[name]; name != "" && !ok {
+			c.captures[name] = capture{path: []string{name}, kind: "string"}
+		}
+	}
+	return &grok{re: re, captures: c.captures}, nil
+}
+
+func (c *compiler) lookup(name string) (string, bool) {
+	if definition, ok := c.definitions[name]; ok {
+		return definition, true
+	}
+	definition, ok := builtinPatterns[name]
+	return definition, ok
+}
+
+func (c *compiler) expand(pattern string, depth int) (string, error) {
+	if depth > maxPatternDepth {
+		return "", fmt.Errorf("pattern %q nests too deep, does it reference itself?", pattern)
+	}
+	var err error
+	expanded := grokReference.ReplaceAllStringFunc(pattern, func(reference string) string {
+		if err != nil {
+			return ""
+		}
+		parts := grokReference.FindStringSubmatch(reference)
+		definition, ok := c.lookup(parts[1])
+		if !ok {
+			err = fmt.Errorf("unknown pattern %%{%s}", parts[1])
+			return ""
+		}
+		inner, expandErr := c.expand(definition, depth+1)
+		if expandErr != nil {
+			err = expandErr
+			return ""
+		}
+		if parts[2] == "" {
+			return "(?:" + inner + ")"
+		}
+
+		group := fmt.Sprintf("grok%d", len(c.captures))
+		kind := parts[3]
+		if kind == "" {
+			kind = "string"
+		}
+		c.captures[group] = capture{path: fieldPath(parts[2]), kind: kind}
+		return "(?P<" + group + ">" + inner + ")"
+	})
+	return expanded, err
+}
+
+// fieldPath accepts both "client.ip" and the Logstash "[client][ip]" notation
+func fieldPath(field string) []string {
+	if strings.HasPrefix(field, "[") {
+		return strings.Split(strings.Trim(field, "[]"), "][")
+	}
+	return strings.Split(field, ".")
+}
+
+// match returns the value of every group, or false when the pattern does not match
+func (g *grok) match(value string) ([]string, bool) {
+	indexes := g.re.FindStringSubmatchIndex(value)
+	if indexes == nil {
+		return nil, false
+	}
+	names := g.re.SubexpNames()
+	values := make([]string, len(names))
+	for i := range names {
+		if start := indexes[2*i]; start >= 0 {
+			values[i] = value[start:indexes[2*i+1]]
+		}
+	}
+	return values, true
+}
+
+// apply writes the captured values of a match into the payload
+func (g *grok) apply(payload common.M, values []string) {
+	for i, name := range g.re.SubexpNames() {
+		c, ok := g.captures[name]
+		if !ok || values[i] == "" {
+			continue
+		}
+		setField(payload, c.path, convert(values[i], c.kind))
+	}
+}
+
+func convert(value string, kind string) interface{} {
+	switch kind {
+	case "int":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return json.Number(strconv.FormatInt(n, 10))
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return json.Number(strconv.FormatFloat(f, 'f', -1, 64))
+		}
+	}
+	return value
+}
+
+// setField sets a nested field, creating the objects on the way
+func setField(payload common.M, path []string, value interface{}) {
+	current := map[string]interface{}(payload)
+	for _, key := range path[:len(path)-1] {
+		switch next := current[key].(type) {
+		case map[string]interface{}:
+			current = next
+		case common.M:
+			current = next
+		default:
+			created := map[string]interface{}{}
+			current[key] = created
+			current = created
+		}
+	}
+	current[path[len(path)-1]] = value
+}
@@ -0,0 +1,147 @@
+package parse
+
+import (
+	"clutch/common"
+	"clutch/config"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	defaultField = "message"
+	// The default format, besides common.CEFFormat and common.LEEFFormat
+	GrokFormat = "grok"
+	// Added to the tags of events no pattern matched
+	FailureTag = "_grokparsefailure"
+	// Added to the tags of CEF or LEEF events that could not be parsed
+	CEFFailureTag  = "_cefparsefailure"
+	LEEFFailureTag = "_leefparsefailure"
+)
+
+// Parsers holds the compiled parser of every event type
+var Parsers = make(map[string]*Parser)
+
+// Parser extracts fields from one string field of an event
+type Parser struct {
+	field      string
+	format     string
+	failureTag string
+	patterns   []*grok
+}
+
+func NewParser(cfg common.ParseConfig) (*Parser, error) {
+	parser := &Parser{field: cfg.Field, format: cfg.Format}
+	if parser.field == "" {
+		parser.field = defaultField
+	}
+	switch cfg.Format {
+	case common.CEFFormat:
+		parser.failureTag = CEFFailureTag
+		return parser, nil
+	case common.LEEFFormat:
+		parser.failureTag = LEEFFailureTag
+		return parser, nil
+	case "", GrokFormat:
+		parser.format, parser.failureTag = GrokFormat, FailureTag
+	default:
+		return nil, fmt.Errorf("unknown format %q", cfg.Format)
+	}
+
+	if len(cfg.Patterns) == 0 {
+		return nil, fmt.Errorf("no patterns")
+	}
+	for _, pattern := range cfg.Patterns {
+		compiled, err := compileGrok(pattern, cfg.Definitions)
+		if err != nil {
+			return nil, err
+		}
+		parser.patterns = append(parser.patterns, compiled)
+	}
+	return parser, nil
+}
+
+// Parse adds the extracted fields to the payload and reports whether the field could be parsed
+func (p *Parser) Parse(payload common.M) bool {
+	value, ok := payload[p.field].(string)
+	if !ok {
+		return false
+	}
+	switch p.format {
+	case common.CEFFormat, common.LEEFFormat:
+		parse := common.ParseCEF
+		if p.format == common.LEEFFormat {
+			parse = common.ParseLEEF
+		}
+		fields, err := parse(value)
+		if err != nil {
+			return false
+		}
+		common.AddSecurityFields(payload, fields)
+		return true
+	}
+	for _, pattern := range p.patterns {
+		if values, ok := pattern.match(value); ok {
+			pattern.apply(payload, values)
+			return true
+		}
+	}
+	return false
+}
+
+// LoadParsers compiles the parsers of the config and of the schemas/<type>_parse.yaml files
+func LoadParsers(parsers map[string]*Parser) {
+	fmt.Println("Loading parsers")
+	configs := make(map[string]common.ParseConfig)
+	for eventType, cfg := range common.GetConfig().Parsers {
+		configs[eventType] = cfg
+	}
+
+	files, err := filepath.Glob("schemas/*_parse.yaml")
+	if err != nil {
+		fmt.Println("Error getting file paths:", err)
+	}
+	for _, file := range files {
+		cfg, err := config.LoadParseConfig(file)
+		if err != nil {
+			fmt.Println("Error loading parse config:", err)
+			continue
+		}
+		eventType := strings.TrimSuffix(filepath.Base(file), "_parse.yaml")
+		configs[eventType] = cfg
+	}
+
+	for eventType, cfg := range configs {
+		parser, err := NewParser(cfg)
+		if err != nil {
+			fmt.Printf("Error compiling parser for %s: %v\n", eventType, err)
+			continue
+		}
+		parsers[eventType] = parser
+	}
+	fmt.Println("Loaded parsers for:", len(parsers), "event types")
+}
+
+// Parse runs the parser of the event type, events that could not be parsed are
+// tagged with the failure tag of the format and passed on unchanged
+func Parse(event common.Event, parsers map[string]*Parser) common.Event {
+	parser, ok := parsers[event.Type]
+	if !ok || event.Payload == nil {
+		return event
+	}
+	if !parser.Parse(event.Payload) {
+		fmt.Printf("Could not parse %s event: %v\n", event.Type, event.Payload[parser.field])
+		addTag(event.Payload, parser.failureTag)
+	}
+	return event
+}
+
+func addTag(payload common.M, tag string) {
+	tags, _ := payload["tags"].([]interface{})
+	for _, existing := range tags {
+		if existing == tag {
+			return
+		}
+	}
+	payload["tags"] = append(tags, tag)
+}
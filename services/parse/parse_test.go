@@ -0,0 +1,150 @@
+package parse
+
+import (
+	"clutch/common"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestBuiltinPatternsCompile(t *testing.T) {
+	for name := range builtinPatterns {
+		if _, err := compileGrok("%{"+name+"}", nil); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestParser(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     common.ParseConfig
+		message string
+		want    common.M
+	}{
+		{
+			name:    "nginx access",
+			cfg:     common.ParseConfig{Patterns: []string{"^%{NGINXACCESS}$"}},
+			message: `203.0.113.7 - - [12/Oct/2024:06:25:24 +0000] "GET /api/machines?id=4 HTTP/1.1" 200 512 "-" "curl/8.5.0"`,
+			want: common.M{
+				"client":    map[string]interface{}{"ip": "203.0.113.7"},
+				"user":      map[string]interface{}{"name": "-"},
+				"timestamp": "12/Oct/2024:06:25:24 +0000",
+				"http": map[string]interface{}{
+					"method":      "GET",
+					"version":     "1.1",
+					"status_code": json.Number("200"),
+					"bytes":       json.Number("512"),
+					"referrer":    "-",
+				},
+				"url":        map[string]interface{}{"original": "/api/machines?id=4"},
+				"user_agent": "curl/8.5.0",
+			},
+		},
+		{
+			name:    "apache common without bytes",
+			cfg:     common.ParseConfig{Patterns: []string{"^%{COMMONAPACHELOG}$"}},
+			message: `10.0.0.2 - frank [10/Oct/2000:13:55:36 -0700] "POST /submit HTTP/1.0" 304 -`,
+			want: common.M{
+				"client":    map[string]interface{}{"ip": "10.0.0.2"},
+				"ident":     "-",
+				"user":      map[string]interface{}{"name": "frank"},
+				"timestamp": "10/Oct/2000:13:55:36 -0700",
+				"http": map[string]interface{}{
+					"method":      "POST",
+					"version":     "1.0",
+					"status_code": json.Number("304"),
+				},
+				"url": map[string]interface{}{"original": "/submit"},
+			},
+		},
+		{
+			name:    "syslog line replaces the message",
+			cfg:     common.ParseConfig{Patterns: []string{"%{SYSLOGLINE}"}},
+			message: `Oct 11 22:14:15 harvester-4 sensord[311]: yield sensor offline`,
+			want: common.M{
+				"timestamp": "Oct 11 22:14:15",
+				"host":      "harvester-4",
+				"program":   "sensord",
+				"pid":       json.Number("311"),
+				"message":   "yield sensor offline",
+			},
+		},
+		{
+			name: "custom definitions and second pattern",
+			cfg: common.ParseConfig{
+				Field:       "line",
+				Patterns:    []string{"^never %{WORD}$", `^%{MACHINE:[machine][id]} rpm=%{NUMBER:[machine][rpm]:float} (?P<state>\w+)$`},
+				Definitions: map[string]string{"MACHINE": `[a-z]+-\d+`},
+			},
+			message: `combine-7 rpm=1800.50 running`,
+			want: common.M{
+				"machine": map[string]interface{}{"id": "combine-7", "rpm": json.Number("1800.5")},
+				"state":   "running",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser, err := NewParser(tt.cfg)
+			if err != nil {
+				t.Fatalf("NewParser() error = %v", err)
+			}
+			field := tt.cfg.Field
+			if field == "" {
+				field = defaultField
+			}
+			payload := common.M{field: tt.message}
+			if !parser.Parse(payload) {
+				t.Fatalf("no pattern matched %q", tt.message)
+			}
+			if _, ok := tt.want[field]; !ok {
+				tt.want[field] = tt.message
+			}
+			if !reflect.DeepEqual(payload, tt.want) {
+				t.Errorf("payload = %#v\nwant %#v", payload, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewParserErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  common.ParseConfig
+	}{
+		{"no patterns", common.ParseConfig{}},
+		{"unknown pattern", common.ParseConfig{Patterns: []string{"%{NOPE:x}"}}},
+		{"recursive", common.ParseConfig{Patterns: []string{"%{LOOP}"}, Definitions: map[string]string{"LOOP": "a%{LOOP}"}}},
+		{"invalid regexp", common.ParseConfig{Patterns: []string{"(%{WORD}"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewParser(tt.cfg); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestParseTagsFailures(t *testing.T) {
+	parser, err := NewParser(common.ParseConfig{Patterns: []string{"^%{NGINXACCESS}$"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsers := map[string]*Parser{"nginx": parser}
+
+	event := Parse(common.Event{Type: "nginx", Payload: common.M{"message": "not an access log", "tags": []interface{}{"edge"}}}, parsers)
+	if !reflect.DeepEqual(event.Payload["tags"], []interface{}{"edge", FailureTag}) {
+		t.Errorf("tags = %v", event.Payload["tags"])
+	}
+	if event.Payload["message"] != "not an access log" {
+		t.Errorf("message = %v", event.Payload["message"])
+	}
+
+	// Other event types pass through untouched
+	other := Parse(common.Event{Type: "syslog", Payload: common.M{"message": "x"}}, parsers)
+	if _, ok := other.Payload["tags"]; ok {
+		t.Errorf("unparsed type was tagged: %v", other.Payload)
+	}
+}
@@ -0,0 +1,32 @@
+package parse
+
+import (
+	"clutch/common"
+	"reflect"
+	"testing"
+)
+
+func TestParserSecurityFormats(t *testing.T) {
+	parser, err := NewParser(common.ParseConfig{Format: common.CEFFormat})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsers := map[string]*Parser{"firewall": parser}
+
+	event := Parse(common.Event{Type: "firewall", Payload: common.M{"message": "CEF:0|V|P|1|2|N|3|suser=admin message=spoofed"}}, parsers)
+	if event.Payload["suser"] != "admin" || event.Payload["tags"] != nil {
+		t.Errorf("payload = %v", event.Payload)
+	}
+	// Fields of the event win over the ones in the message
+	if event.Payload["message"] != "CEF:0|V|P|1|2|N|3|suser=admin message=spoofed" {
+		t.Errorf("message = %v", event.Payload["message"])
+	}
+	event = Parse(common.Event{Type: "firewall", Payload: common.M{"message": "LEEF:1.0|V|P|1|2|a=b"}}, parsers)
+	if !reflect.DeepEqual(event.Payload["tags"], []interface{}{CEFFailureTag}) {
+		t.Errorf("tags = %v", event.Payload["tags"])
+	}
+
+	if _, err := NewParser(common.ParseConfig{Format: "xml"}); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
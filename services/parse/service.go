@@ -0,0 +1,28 @@
+package parse
+
+import (
+	"clutch/common"
+	"context"
+)
+
+func init() {
+	common.RegisterService("parse", func() common.Service { return &service{} })
+}
+
+// service runs the parsers of every event type as a pipeline stage
+type service struct{}
+
+func (s *service) Name() string { return "parse" }
+
+func (s *service) Init(cfg common.StageConfig) error {
+	LoadParsers(Parsers)
+	return nil
+}
+
+func (s *service) Run(ctx context.Context, in <-chan common.Event, out chan<- common.Event) error {
+	return common.ProcessEvents(ctx, in, out, func(event common.Event) []common.Event {
+		return []common.Event{Parse(event, Parsers)}
+	})
+}
+
+func (s *service) Close() error { return nil }
@@ -0,0 +1,351 @@
+package services
+
+import (
+	"clutch/common"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+)
+
+// Events a stage can have queued before the stages feeding it wait
+const stageBuffer = 1000
+
+type stage struct {
+	name    string
+	types   []string
+	service common.Service
+	// Events queued for the stage
+	in chan common.Event
+	// Unbuffered, the events wait in in until the service takes them
+	serviceIn  chan common.Event
+	serviceOut chan common.Event
+	next       []*stage
+	// One per stage sending here (the graph itself for the first stages), in is
+	// closed once all of them are done
+	senders sync.WaitGroup
+}
+
+// Graph runs every stage of the pipeline in its own goroutine
+type Graph struct {
+	stages []*stage
+	roots  []*stage
+	ctx    context.Context
+	// Done once every stage has passed on its last event
+	running sync.WaitGroup
+}
+
+// NewGraph checks the pipeline config and initializes a service for every stage,
+// nothing runs until Start
+func NewGraph(cfg common.PipelineConfig) (*Graph, error) {
+	if len(cfg.Stages) == 0 {
+		return nil, errors.New("the pipeline has no stages")
+	}
+	g := &Graph{}
+	if err := g.build(cfg); err != nil {
+		// Release the services initialized before the error
+		for _, s := range g.stages {
+			s.service.Close()
+		}
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *Graph) build(cfg common.PipelineConfig) error {
+	stages := make(map[string]*stage)
+	for i, stageConfig := range cfg.Stages {
+		if stageConfig.Name == "" {
+			return fmt.Errorf("stage %d has no name", i)
+		}
+		if _, ok := stages[stageConfig.Name]; ok {
+			return fmt.Errorf("duplicate stage %q", stageConfig.Name)
+		}
+		for _, pattern := range stageConfig.Types {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("stage %q: invalid type pattern %q", stageConfig.Name, pattern)
+			}
+		}
+		service, ok := common.NewService(stageConfig.Service)
+		if !ok {
+			return fmt.Errorf("stage %q: unknown service %q, registered: %v", stageConfig.Name, stageConfig.Service, common.Services())
+		}
+		if err := service.Init(stageConfig); err != nil {
+			return fmt.Errorf("stage %q: %w", stageConfig.Name, err)
+		}
+		s := &stage{
+			name:       stageConfig.Name,
+			types:      stageConfig.Types,
+			service:    service,
+			in:         make(chan common.Event, stageBuffer),
+			serviceIn:  make(chan common.Event),
+			serviceOut: make(chan common.Event),
+		}
+		stages[s.name] = s
+		g.stages = append(g.stages, s)
+	}
+
+	incoming := make(map[*stage]int)
+	for _, edge := range cfg.Edges {
+		from, ok := stages[edge.From]
+		if !ok {
+			return fmt.Errorf("edge from unknown stage %q", edge.From)
+		}
+		for _, name := range edge.To {
+			to, ok := stages[name]
+			if !ok {
+				return fmt.Errorf("edge from %q to unknown stage %q", edge.From, name)
+			}
+			if linked(from.next, to) {
+				continue
+			}
+			from.next = append(from.next, to)
+			incoming[to]++
+		}
+	}
+	for _, s := range g.stages {
+		if incoming[s] == 0 {
+			g.roots = append(g.roots, s)
+			s.senders.Add(1)
+		} else {
+			s.senders.Add(incoming[s])
+		}
+	}
+	return g.checkCycles(incoming)
+}
+
+func linked(stages []*stage, target *stage) bool {
+	for _, s := range stages {
+		if s == target {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCycles removes the stages without incoming edges until none are left, whatever
+// remains is part of a cycle
+func (g *Graph) checkCycles(incoming map[*stage]int) error {
+	remaining := make(map[*stage]int, len(incoming))
+	for s, count := range incoming {
+		remaining[s] = count
+	}
+	queue := append([]*stage(nil), g.roots...)
+	visited := 0
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		visited++
+		for _, next := range s.next {
+			remaining[next]--
+			if remaining[next] == 0 {
+				queue = append(queue, next)
+			}
+		}
+	}
+	if visited != len(g.stages) {
+		return errors.New("the pipeline has a cycle")
+	}
+	return nil
+}
+
+// Start runs the service of every stage. Cancelling ctx stops them at once, queued
+// events are dropped.
+func (g *Graph) Start(ctx context.Context) {
+	g.ctx = ctx
+	for _, s := range g.stages {
+		fmt.Printf("Starting stage %s (%s)\n", s.name, s.service.Name())
+		g.running.Add(1)
+		go func() {
+			s.senders.Wait()
+			close(s.in)
+		}()
+		go s.run(ctx)
+		go g.drive(s)
+	}
+}
+
+// Send hands an event to the first stages of the pipeline. The caller still holds the
+// event afterwards, see common.Pending.
+func (g *Graph) Send(event common.Event) {
+	forward(g.ctx, g.roots, event)
+}
+
+// Close stops accepting events and returns once every stage has handled what was
+// queued and its service is closed. Nothing may be sent afterwards.
+func (g *Graph) Close() {
+	for _, s := range g.roots {
+		s.senders.Done()
+	}
+	g.running.Wait()
+}
+
+func (s *stage) run(ctx context.Context) {
+	if err := s.service.Run(ctx, s.serviceIn, s.serviceOut); err != nil {
+		fmt.Printf("Stage %s stopped with %d events queued: %v\n", s.name, len(s.in), err)
+	}
+	if err := s.service.Close(); err != nil {
+		fmt.Printf("Error closing stage %s: %v\n", s.name, err)
+	}
+	close(s.serviceOut)
+}
+
+// drive hands the queued events to the service and passes what it outputs on to the
+// next stages. When the service stops the next stages are told nothing more is coming
+// from here.
+func (g *Graph) drive(s *stage) {
+	defer g.running.Done()
+	queue := s.in
+	var waiting common.Event
+	var hasWaiting bool
+	for {
+		// Only offer an event to the service once there is one
+		var read <-chan common.Event
+		var feed chan<- common.Event
+		if hasWaiting {
+			feed = s.serviceIn
+		} else {
+			read = queue
+		}
+		select {
+		case event, ok := <-read:
+			if !ok {
+				queue = nil
+				close(s.serviceIn)
+				continue
+			}
+			waiting, hasWaiting = event, true
+		case feed <- waiting:
+			hasWaiting = false
+		case event, ok := <-s.serviceOut:
+			if !ok {
+				for _, next := range s.next {
+					next.senders.Done()
+				}
+				return
+			}
+			// The service held the event for us, the next stages take over from here
+			forward(g.ctx, s.next, event)
+			event.Meta.Pending.Done()
+		}
+	}
+}
+
+func (s *stage) accepts(eventType string) bool {
+	if len(s.types) == 0 {
+		return true
+	}
+	for _, pattern := range s.types {
+		if matched, _ := path.Match(pattern, eventType); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// forward sends the event to the stages accepting its type. Branches run concurrently,
+// so every branch after the first gets its own deep copy of the payload. Each of them holds
+// the event until its service is done with it.
+func forward(ctx context.Context, stages []*stage, event common.Event) {
+	var targets []*stage
+	for _, s := range stages {
+		if s.accepts(event.Type) {
+			targets = append(targets, s)
+		}
+	}
+	event.Meta.Pending.Add(len(targets))
+	events := make([]common.Event, len(targets))
+	for i := range targets {
+		events[i] = event
+		if i > 0 {
+			events[i].Payload = copyValue(event.Payload).(common.M)
+		}
+	}
+	for i, s := range targets {
+		select {
+		case s.in <- events[i]:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// copyValue copies the maps and slices of a decoded payload all the way down, anything
+// else is a value and shared as is
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case common.M:
+		copied := make(common.M, len(v))
+		for key, item := range v {
+			copied[key] = copyValue(item)
+		}
+		return copied
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			copied[key] = copyValue(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = copyValue(item)
+		}
+		return copied
+	case []common.M:
+		copied := make([]common.M, len(v))
+		for i, item := range v {
+			copied[i] = copyValue(item).(common.M)
+		}
+		return copied
+	}
+	return value
+}
+
+// defaultPipeline wires the services list the way the distributor always has:
+// parse, validate and publish, then store, mask and synthesize side by side
+func defaultPipeline(services []string) common.PipelineConfig {
+	enabled := make(map[string]bool)
+	for _, service := range services {
+		enabled[service] = true
+	}
+	var cfg common.PipelineConfig
+	add := func(name string, from ...string) {
+		cfg.Stages = append(cfg.Stages, common.StageConfig{Name: name, Service: name})
+		for _, previous := range from {
+			cfg.Edges = append(cfg.Edges, common.EdgeConfig{From: previous, To: []string{name}})
+		}
+	}
+
+	var previous []string
+	if enabled["parse"] {
+		add("parse")
+		previous = []string{"parse"}
+	}
+	if enabled["validate"] {
+		add("validate", previous...)
+		cfg.Stages[len(cfg.Stages)-1].Options = map[string]interface{}{"store_rejected": enabled["storage"]}
+		previous = []string{"validate"}
+	}
+	add("publish", previous...)
+	if enabled["storage"] {
+		add("store", "publish")
+	}
+	var copies []string
+	if enabled["masking"] {
+		add("mask", "publish")
+		copies = append(copies, "mask")
+	}
+	if enabled["masking"] || enabled["synth"] {
+		add("synth", "publish")
+		copies = append(copies, "synth")
+	}
+	if enabled["mask_storage"] && len(copies) > 0 {
+		cfg.Stages = append(cfg.Stages, common.StageConfig{Name: "store_masked", Service: "store"})
+		for _, from := range copies {
+			cfg.Edges = append(cfg.Edges, common.EdgeConfig{From: from, To: []string{"store_masked"}})
+		}
+	}
+	return cfg
+}
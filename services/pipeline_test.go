@@ -0,0 +1,337 @@
+package services
+
+import (
+	"clutch/common"
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// funcService runs process on every event
+type funcService struct {
+	name    string
+	process func(event common.Event) []common.Event
+}
+
+func (s *funcService) Name() string                      { return s.name }
+func (s *funcService) Init(cfg common.StageConfig) error { return nil }
+func (s *funcService) Close() error {
+	closed <- s.name
+	return nil
+}
+func (s *funcService) Run(ctx context.Context, in <-chan common.Event, out chan<- common.Event) error {
+	return common.ProcessEvents(ctx, in, out, s.process)
+}
+
+var (
+	left   = make(chan common.Event, 10)
+	right  = make(chan common.Event, 10)
+	closed = make(chan string, 10)
+	// test_hold works on an event until it is told to let go
+	release = make(chan struct{})
+)
+
+func sink(events chan common.Event) func(event common.Event) []common.Event {
+	return func(event common.Event) []common.Event {
+		events <- event
+		return nil
+	}
+}
+
+// stuckService never reads its input, only cancelling the graph stops it
+type stuckService struct{}
+
+func (stuckService) Name() string                      { return "test_stuck" }
+func (stuckService) Init(cfg common.StageConfig) error { return nil }
+func (stuckService) Close() error                      { return nil }
+func (stuckService) Run(ctx context.Context, in <-chan common.Event, out chan<- common.Event) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func init() {
+	common.RegisterService("test_stuck", func() common.Service { return stuckService{} })
+	common.RegisterService("test_left", func() common.Service { return &funcService{name: "test_left", process: sink(left)} })
+	common.RegisterService("test_right", func() common.Service { return &funcService{name: "test_right", process: sink(right)} })
+	common.RegisterService("test_rename", func() common.Service {
+		return &funcService{name: "test_rename", process: func(event common.Event) []common.Event {
+			event.Type = "renamed_" + event.Type
+			event.Payload["renamed"] = true
+			return []common.Event{event}
+		}}
+	})
+	common.RegisterService("test_hold", func() common.Service {
+		return &funcService{name: "test_hold", process: func(event common.Event) []common.Event {
+			<-release
+			return nil
+		}}
+	})
+	common.RegisterService("test_nest", func() common.Service {
+		return &funcService{name: "test_nest", process: func(event common.Event) []common.Event {
+			reading := event.Payload["reading"].(common.M)
+			reading["value"] = 2
+			reading["history"].([]interface{})[0] = 2
+			left <- event
+			return nil
+		}}
+	})
+	common.RegisterService("test_encode", func() common.Service {
+		return &funcService{name: "test_encode", process: func(event common.Event) []common.Event {
+			if _, err := json.Marshal(event.Payload); err != nil {
+				panic(err)
+			}
+			right <- event
+			return nil
+		}}
+	})
+}
+
+func receive(t *testing.T, events chan common.Event) common.Event {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event reached the stage")
+	}
+	return common.Event{}
+}
+
+func TestGraphRouting(t *testing.T) {
+	graph, err := NewGraph(common.PipelineConfig{
+		Stages: []common.StageConfig{
+			{Name: "start", Service: "publish"},
+			{Name: "rename", Service: "test_rename", Types: []string{"sensor_*"}},
+			{Name: "all", Service: "test_left"},
+			{Name: "renamed", Service: "test_right", Types: []string{"renamed_*"}},
+		},
+		Edges: []common.EdgeConfig{
+			{From: "start", To: []string{"rename", "all"}},
+			{From: "rename", To: []string{"renamed"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph.Start(context.Background())
+
+	graph.Send(common.Event{Type: "sensor_a", Payload: common.M{"machine_id": "4"}})
+	graph.Send(common.Event{Type: "billing", Payload: common.M{}})
+
+	if event := receive(t, right); event.Type != "renamed_sensor_a" {
+		t.Errorf("renamed stage got %+v", event)
+	}
+	// The other branch has its own payload and never sees the rename
+	first, second := receive(t, left), receive(t, left)
+	if first.Type != "sensor_a" || second.Type != "billing" || !reflect.DeepEqual(first.Payload, common.M{"machine_id": "4"}) {
+		t.Errorf("all stage got %+v and %+v", first, second)
+	}
+	select {
+	case event := <-right:
+		t.Errorf("billing should not pass the rename stage, got %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestGraphCopiesNestedPayload(t *testing.T) {
+	graph, err := NewGraph(common.PipelineConfig{
+		Stages: []common.StageConfig{
+			{Name: "start", Service: "publish"},
+			{Name: "nest", Service: "test_nest"},
+			{Name: "encode", Service: "test_encode"},
+		},
+		Edges: []common.EdgeConfig{{From: "start", To: []string{"nest", "encode"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph.Start(context.Background())
+	defer graph.Close()
+
+	// Run with -race: the branches must not share the nested map or slice
+	for i := 0; i < 20; i++ {
+		graph.Send(common.Event{Type: "sensor_a", Payload: common.M{
+			"reading": common.M{"value": 1, "history": []interface{}{1, 1}},
+		}})
+	}
+	want := common.M{"reading": common.M{"value": 1, "history": []interface{}{1, 1}}}
+	for i := 0; i < 20; i++ {
+		receive(t, left)
+		if event := receive(t, right); !reflect.DeepEqual(event.Payload, want) {
+			t.Errorf("encode stage got %v, want %v", event.Payload, want)
+		}
+	}
+}
+
+func TestGraphClose(t *testing.T) {
+	for len(closed) > 0 {
+		<-closed
+	}
+	graph, err := NewGraph(common.PipelineConfig{
+		Stages: []common.StageConfig{
+			{Name: "a", Service: "publish"},
+			{Name: "b", Service: "publish"},
+			{Name: "sink", Service: "test_left"},
+		},
+		Edges: []common.EdgeConfig{
+			{From: "a", To: []string{"b", "sink"}},
+			{From: "b", To: []string{"sink"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph.Start(context.Background())
+	for i := 0; i < 3; i++ {
+		graph.Send(common.Event{Type: "a", Payload: common.M{}})
+	}
+
+	// Close returns once both paths to the sink are drained
+	graph.Close()
+	if len(left) != 6 || len(closed) != 1 {
+		t.Errorf("sink got %d events and was closed %d times", len(left), len(closed))
+	}
+	for len(left) > 0 {
+		<-left
+	}
+	<-closed
+}
+
+func TestGraphCommit(t *testing.T) {
+	graph, err := NewGraph(common.PipelineConfig{
+		Stages: []common.StageConfig{
+			{Name: "start", Service: "publish"},
+			{Name: "left", Service: "test_left"},
+			{Name: "rename", Service: "test_rename"},
+			{Name: "hold", Service: "test_hold"},
+		},
+		Edges: []common.EdgeConfig{
+			{From: "start", To: []string{"left", "rename"}},
+			{From: "rename", To: []string{"hold"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph.Start(context.Background())
+	defer graph.Close()
+	committed := make(chan string, 1)
+	event := common.Event{Type: "a", Payload: common.M{}}
+	event.Meta.Pending = common.NewPending(func() { committed <- event.Type })
+	graph.Send(event)
+	event.Meta.Pending.Done()
+
+	receive(t, left)
+	// The renamed event is still being worked on
+	select {
+	case eventType := <-committed:
+		t.Fatalf("%s committed while a stage still holds it", eventType)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// No other event has to come along for it to be committed
+	release <- struct{}{}
+	select {
+	case <-committed:
+	case <-time.After(time.Second):
+		t.Fatal("not committed once every stage was done with it")
+	}
+}
+
+func TestGraphCancel(t *testing.T) {
+	graph, err := NewGraph(common.PipelineConfig{Stages: []common.StageConfig{{Name: "stuck", Service: "test_stuck"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	graph.Start(ctx)
+	graph.Send(common.Event{Type: "a", Payload: common.M{}})
+
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		graph.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close() did not return after cancel")
+	}
+}
+
+func TestNewGraphErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  common.PipelineConfig
+	}{
+		{"empty", common.PipelineConfig{}},
+		{"unnamed", common.PipelineConfig{Stages: []common.StageConfig{{Service: "test_left"}}}},
+		{"duplicate", common.PipelineConfig{Stages: []common.StageConfig{{Name: "a", Service: "test_left"}, {Name: "a", Service: "test_left"}}}},
+		{"unknown service", common.PipelineConfig{Stages: []common.StageConfig{{Name: "a", Service: "nope"}}}},
+		{"bad pattern", common.PipelineConfig{Stages: []common.StageConfig{{Name: "a", Service: "test_left", Types: []string{"["}}}}},
+		{"edge from unknown", common.PipelineConfig{
+			Stages: []common.StageConfig{{Name: "a", Service: "test_left"}},
+			Edges:  []common.EdgeConfig{{From: "b", To: []string{"a"}}},
+		}},
+		{"edge to unknown", common.PipelineConfig{
+			Stages: []common.StageConfig{{Name: "a", Service: "test_left"}},
+			Edges:  []common.EdgeConfig{{From: "a", To: []string{"b"}}},
+		}},
+		{"cycle", common.PipelineConfig{
+			Stages: []common.StageConfig{{Name: "in", Service: "publish"}, {Name: "a", Service: "publish"}, {Name: "b", Service: "publish"}},
+			Edges:  []common.EdgeConfig{{From: "in", To: []string{"a"}}, {From: "a", To: []string{"b"}}, {From: "b", To: []string{"a"}}},
+		}},
+	}
+	for _, tt := range tests {
+		if _, err := NewGraph(tt.cfg); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestNewGraphClosesServices(t *testing.T) {
+	for len(closed) > 0 {
+		<-closed
+	}
+	// The store can not start without a database, the stage before it is released
+	_, err := NewGraph(common.PipelineConfig{Stages: []common.StageConfig{
+		{Name: "first", Service: "test_left"},
+		{Name: "store", Service: "store"},
+	}})
+	if err == nil {
+		t.Fatal("expected an error without a database")
+	}
+	select {
+	case name := <-closed:
+		if name != "test_left" {
+			t.Errorf("closed %s", name)
+		}
+	default:
+		t.Errorf("the first stage was not closed")
+	}
+}
+
+func TestDefaultPipeline(t *testing.T) {
+	cfg := defaultPipeline([]string{"parse", "validate", "storage", "masking", "mask_storage", "chat"})
+	edges := make(map[string][]string)
+	for _, edge := range cfg.Edges {
+		edges[edge.From] = append(edges[edge.From], edge.To...)
+	}
+	want := map[string][]string{
+		"parse":    {"validate"},
+		"validate": {"publish"},
+		"publish":  {"store", "mask", "synth"},
+		"mask":     {"store_masked"},
+		"synth":    {"store_masked"},
+	}
+	if !reflect.DeepEqual(edges, want) {
+		t.Errorf("edges = %v, want %v", edges, want)
+	}
+
+	cfg = defaultPipeline(nil)
+	if len(cfg.Stages) != 1 || cfg.Stages[0].Service != "publish" || len(cfg.Edges) != 0 {
+		t.Errorf("pipeline without services = %+v", cfg)
+	}
+}
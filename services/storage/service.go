@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"clutch/common"
+	"context"
+	"errors"
+)
+
+func init() {
+	common.RegisterService("store", func() common.Service { return &service{} })
+}
+
+// service stores every event into the index named after its type
+type service struct {
+	store common.Store
+}
+
+func (s *service) Name() string { return "store" }
+
+func (s *service) Init(cfg common.StageConfig) error {
+	s.store = common.GetConfig().Store
+	if s.store == nil {
+		return errors.New("no database configured")
+	}
+	return nil
+}
+
+func (s *service) Run(ctx context.Context, in <-chan common.Event, out chan<- common.Event) error {
+	return common.ProcessEvents(ctx, in, out, func(event common.Event) []common.Event {
+		StoreEvent(s.store, event)
+		return nil
+	})
+}
+
+func (s *service) Close() error { return nil }
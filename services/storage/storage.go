@@ -30,28 +30,9 @@ func InitializeStore(cfg *common.DatabaseConfig) (common.Store, error) {
 		return nil, fmt.Errorf("unsupported store type: %s", cfg.Type)
 	}
 }
-func StoreMasks(maskedStorageChan *chan common.Event) {
 
-	fmt.Println("Starting masked storage service")
-	cfg := common.GetConfig()
-	store := cfg.Store
-
-	for event := range *maskedStorageChan {
-
-		fmt.Println("---------- Storing ----------")
-		fmt.Println("New Masked or Synthesized event:", event)
-		store.InsertDocument(event.Type, event.Payload)
-		fmt.Println("---------- Done Storing ----------")
-	}
-}
-
-func Store(storageChan *chan common.Event) {
-	fmt.Println("Starting storage service")
-	cfg := common.GetConfig()
-	store := cfg.Store
-
-	for event := range *storageChan {
-		fmt.Println("Storing event:", event)
-		store.InsertDocument(event.Type, event.Payload)
-	}
+// StoreEvent writes an event into the index named after its type
+func StoreEvent(store common.Store, event common.Event) {
+	fmt.Println("Storing event:", event)
+	store.InsertDocument(event.Type, event.Document())
 }
@@ -0,0 +1,26 @@
+package subscribe
+
+import (
+	"clutch/common"
+	"context"
+)
+
+func init() {
+	common.RegisterService("publish", func() common.Service { return &service{} })
+}
+
+// service streams the events passing through it to the raw stream subscribers
+type service struct{}
+
+func (s *service) Name() string { return "publish" }
+
+func (s *service) Init(cfg common.StageConfig) error { return nil }
+
+func (s *service) Run(ctx context.Context, in <-chan common.Event, out chan<- common.Event) error {
+	return common.ProcessEvents(ctx, in, out, func(event common.Event) []common.Event {
+		Publish(RawStream, event.Type, event)
+		return []common.Event{event}
+	})
+}
+
+func (s *service) Close() error { return nil }
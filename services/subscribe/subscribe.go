@@ -0,0 +1,309 @@
+package subscribe
+
+import (
+	"bytes"
+	"clutch/common"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Streams a subscription can watch
+const (
+	RawStream         = "raw"
+	MaskedStream      = "masked"
+	SynthesizedStream = "synthesized"
+)
+
+// Events a subscriber has not read yet, once full it is dropped
+const subscriptionBuffer = 256
+
+var (
+	subscriptions      = make(map[string]*Subscription)
+	subscriptionsMutex sync.RWMutex
+
+	ErrSlowSubscriber     = errors.New("subscriber too slow, events were dropped")
+	errSubscriptionClosed = errors.New("subscription closed")
+)
+
+// Predicate compares a payload field, dotted names reach into nested objects.
+// Op is eq (the default), ne, exists, contains, gt, gte, lt or lte.
+type Predicate struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+}
+
+// Filter selects the events of a subscription. Types are glob patterns ("sensor_*")
+// matched against the type the event was ingested with, every predicate has to match.
+type Filter struct {
+	Streams []string    `json:"streams"`
+	Types   []string    `json:"types"`
+	Where   []Predicate `json:"where"`
+}
+
+// Message is an event pushed to a subscriber. The payload is encoded when it is
+// published, later stages keep changing the map while the message waits to be sent.
+type Message struct {
+	ID      string          `json:"id,omitempty"`
+	Stream  string          `json:"stream"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Subscription receives the events matching its filter until it is closed or falls behind
+type Subscription struct {
+	ID     string
+	Events chan Message
+
+	filter Filter
+	allow  func(eventType string) bool
+	done   chan struct{}
+	once   sync.Once
+	err    error
+}
+
+// ParseFilter decodes and checks a filter, it watches the raw stream when none is given
+func ParseFilter(message []byte) (Filter, error) {
+	var filter Filter
+	decoder := json.NewDecoder(bytes.NewReader(message))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&filter); err != nil {
+		return Filter{}, fmt.Errorf("invalid filter: %w", err)
+	}
+	if len(filter.Streams) == 0 {
+		filter.Streams = []string{RawStream}
+	}
+	for _, stream := range filter.Streams {
+		if stream != RawStream && stream != MaskedStream && stream != SynthesizedStream {
+			return Filter{}, fmt.Errorf("unknown stream %q", stream)
+		}
+	}
+	for _, pattern := range filter.Types {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return Filter{}, fmt.Errorf("invalid type pattern %q", pattern)
+		}
+	}
+	for i, predicate := range filter.Where {
+		switch predicate.Op {
+		case "":
+			filter.Where[i].Op = "eq"
+		case "eq", "ne", "exists", "contains", "gt", "gte", "lt", "lte":
+		default:
+			return Filter{}, fmt.Errorf("unknown operator %q", predicate.Op)
+		}
+		if predicate.Field == "" {
+			return Filter{}, errors.New("predicate field is required")
+		}
+	}
+	return filter, nil
+}
+
+// Subscribe registers a subscription. allow, when set, hides the event types the
+// subscriber may not see.
+func Subscribe(filter Filter, allow func(eventType string) bool) *Subscription {
+	subscription := &Subscription{
+		ID:     uuid.NewString(),
+		Events: make(chan Message, subscriptionBuffer),
+		filter: filter,
+		allow:  allow,
+		done:   make(chan struct{}),
+	}
+	subscriptionsMutex.Lock()
+	subscriptions[subscription.ID] = subscription
+	subscriptionsMutex.Unlock()
+	return subscription
+}
+
+// Close unregisters the subscription
+func (s *Subscription) Close() {
+	s.close(errSubscriptionClosed)
+}
+
+func (s *Subscription) close(err error) {
+	subscriptionsMutex.Lock()
+	delete(subscriptions, s.ID)
+	subscriptionsMutex.Unlock()
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+// Done is closed once the subscription has been closed or dropped
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err tells why the subscription ended, ErrSlowSubscriber when it was dropped
+func (s *Subscription) Err() error {
+	<-s.done
+	return s.err
+}
+
+// Publish hands an event to every matching subscription without ever blocking the
+// pipeline. sourceType is the type the event was ingested with.
+func Publish(stream string, sourceType string, event common.Event) {
+	subscriptionsMutex.RLock()
+	if len(subscriptions) == 0 {
+		subscriptionsMutex.RUnlock()
+		return
+	}
+	var slow []*Subscription
+	var payload json.RawMessage
+	for _, subscription := range subscriptions {
+		if !subscription.matches(stream, sourceType, event.Payload) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			if payload, err = json.Marshal(event.Payload); err != nil {
+				subscriptionsMutex.RUnlock()
+				fmt.Printf("Error encoding %s event for subscribers: %v\n", event.Type, err)
+				return
+			}
+		}
+		select {
+		case subscription.Events <- Message{ID: event.Meta.ID, Stream: stream, Type: event.Type, Payload: payload}:
+		default:
+			slow = append(slow, subscription)
+		}
+	}
+	subscriptionsMutex.RUnlock()
+
+	for _, subscription := range slow {
+		fmt.Println("Dropping slow subscriber:", subscription.ID)
+		subscription.close(ErrSlowSubscriber)
+	}
+}
+
+func (s *Subscription) matches(stream string, sourceType string, payload common.M) bool {
+	if !contains(s.filter.Streams, stream) {
+		return false
+	}
+	if s.allow != nil && !s.allow(sourceType) {
+		return false
+	}
+	if len(s.filter.Types) > 0 && !matchesAny(s.filter.Types, sourceType) {
+		return false
+	}
+	for _, predicate := range s.filter.Where {
+		if !predicate.matches(payload) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(patterns []string, eventType string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, eventType); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup reads a dotted field out of nested objects
+func lookup(payload common.M, field string) (interface{}, bool) {
+	var current interface{} = map[string]interface{}(payload)
+	for _, key := range strings.Split(field, ".") {
+		var object map[string]interface{}
+		switch value := current.(type) {
+		case map[string]interface{}:
+			object = value
+		case common.M:
+			object = value
+		default:
+			return nil, false
+		}
+		next, ok := object[key]
+		if !ok {
+			return nil, false
+		}
+		current = next
+	}
+	return current, true
+}
+
+func (p Predicate) matches(payload common.M) bool {
+	value, ok := lookup(payload, p.Field)
+	switch p.Op {
+	case "exists":
+		return ok
+	case "ne":
+		return !ok || !equal(value, p.Value)
+	}
+	if !ok {
+		return false
+	}
+	switch p.Op {
+	case "eq":
+		return equal(value, p.Value)
+	case "contains":
+		return strings.Contains(fmt.Sprint(value), fmt.Sprint(p.Value))
+	}
+
+	a, aOK := number(value)
+	b, bOK := number(p.Value)
+	if !aOK || !bOK {
+		return false
+	}
+	switch p.Op {
+	case "gt":
+		return a > b
+	case "gte":
+		return a >= b
+	case "lt":
+		return a < b
+	case "lte":
+		return a <= b
+	}
+	return false
+}
+
+// equal compares numbers by value, so 200 matches json.Number("200.0"), and anything else by its text
+func equal(a interface{}, b interface{}) bool {
+	if x, ok := number(a); ok {
+		if y, ok := number(b); ok {
+			return x == y
+		}
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func number(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,126 @@
+package subscribe
+
+import (
+	"clutch/common"
+	"encoding/json"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	filter, err := ParseFilter([]byte(`{"types":["sensor_*"],"where":[{"field":"machine.id","value":"4"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filter.Streams) != 1 || filter.Streams[0] != RawStream || filter.Where[0].Op != "eq" {
+		t.Errorf("filter = %+v", filter)
+	}
+
+	for _, message := range []string{
+		`not json`,
+		`{"streams":["cooked"]}`,
+		`{"types":["["]}`,
+		`{"where":[{"field":"a","op":"like"}]}`,
+		`{"where":[{"op":"exists"}]}`,
+		`{"type":"a"}`,
+	} {
+		if _, err := ParseFilter([]byte(message)); err == nil {
+			t.Errorf("ParseFilter(%s) expected an error", message)
+		}
+	}
+}
+
+func TestPredicates(t *testing.T) {
+	payload := common.M{
+		"status":  "running",
+		"rpm":     json.Number("1800.5"),
+		"machine": map[string]interface{}{"id": "4", "zone": "north field"},
+	}
+	tests := []struct {
+		predicate Predicate
+		want      bool
+	}{
+		{Predicate{Field: "status", Op: "eq", Value: "running"}, true},
+		{Predicate{Field: "status", Op: "ne", Value: "running"}, false},
+		{Predicate{Field: "missing", Op: "ne", Value: "x"}, true},
+		{Predicate{Field: "machine.id", Op: "eq", Value: json.Number("4")}, true},
+		{Predicate{Field: "machine.zone", Op: "contains", Value: "north"}, true},
+		{Predicate{Field: "machine.id.deeper", Op: "exists"}, false},
+		{Predicate{Field: "machine", Op: "exists"}, true},
+		{Predicate{Field: "rpm", Op: "gt", Value: 1800.0}, true},
+		{Predicate{Field: "rpm", Op: "lte", Value: json.Number("1800")}, false},
+		{Predicate{Field: "status", Op: "gt", Value: 1}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.predicate.matches(payload); got != tt.want {
+			t.Errorf("%+v matches = %v, want %v", tt.predicate, got, tt.want)
+		}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	filter, err := ParseFilter([]byte(`{"streams":["raw","masked"],"types":["sensor_*"],"where":[{"field":"ok","value":true}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscription := Subscribe(filter, func(eventType string) bool { return eventType != "sensor_secret" })
+	defer subscription.Close()
+
+	event := common.Event{Type: "sensor_a", Payload: common.M{"ok": true}}
+	Publish(RawStream, event.Type, event)
+	Publish(MaskedStream, event.Type, common.Event{Type: "masked_sensor_a", Payload: common.M{"ok": true}})
+	Publish(SynthesizedStream, event.Type, event)
+	Publish(RawStream, "billing", common.Event{Type: "billing", Payload: common.M{"ok": true}})
+	Publish(RawStream, "sensor_b", common.Event{Type: "sensor_b", Payload: common.M{"ok": false}})
+	Publish(RawStream, "sensor_secret", common.Event{Type: "sensor_secret", Payload: common.M{"ok": true}})
+
+	if len(subscription.Events) != 2 {
+		t.Fatalf("queued messages = %d, want 2", len(subscription.Events))
+	}
+	if message := <-subscription.Events; message.Stream != RawStream || message.Type != "sensor_a" {
+		t.Errorf("message = %+v", message)
+	}
+	if message := <-subscription.Events; message.Stream != MaskedStream || message.Type != "masked_sensor_a" {
+		t.Errorf("message = %+v", message)
+	}
+}
+
+func TestPublishEncodesPayload(t *testing.T) {
+	subscription := Subscribe(Filter{Streams: []string{RawStream}}, nil)
+	defer subscription.Close()
+
+	// Later stages keep changing the payload while the message is queued
+	event := common.Event{Type: "a", Payload: common.M{"reading": common.M{"value": 1}}}
+	Publish(RawStream, event.Type, event)
+	event.Payload["reading"].(common.M)["value"] = 2
+
+	if message := <-subscription.Events; string(message.Payload) != `{"reading":{"value":1}}` {
+		t.Errorf("payload = %s, want the payload as it was published", message.Payload)
+	}
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	slow := Subscribe(Filter{Streams: []string{RawStream}}, nil)
+	fast := Subscribe(Filter{Streams: []string{RawStream}}, nil)
+	defer fast.Close()
+
+	event := common.Event{Type: "a", Payload: common.M{}}
+	for i := 0; i <= subscriptionBuffer; i++ {
+		Publish(RawStream, event.Type, event)
+		<-fast.Events
+	}
+	if err := slow.Err(); err != ErrSlowSubscriber {
+		t.Errorf("slow subscriber error = %v, want ErrSlowSubscriber", err)
+	}
+	select {
+	case <-fast.Done():
+		t.Errorf("fast subscriber was dropped")
+	default:
+	}
+
+	subscriptionsMutex.RLock()
+	_, registered := subscriptions[slow.ID]
+	subscriptionsMutex.RUnlock()
+	if registered {
+		t.Errorf("slow subscriber is still registered")
+	}
+}
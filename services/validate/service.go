@@ -0,0 +1,45 @@
+package validate
+
+import (
+	"clutch/common"
+	"context"
+	"fmt"
+)
+
+func init() {
+	common.RegisterService("validate", func() common.Service { return &service{} })
+}
+
+// service passes on the events matching their schema. Rejected events are stored
+// in RejectedIndex unless the store_rejected option is false.
+type service struct {
+	store common.Store
+}
+
+func (s *service) Name() string { return "validate" }
+
+func (s *service) Init(cfg common.StageConfig) error {
+	LoadSchemas(Schemas)
+	if storeRejected, ok := cfg.Options["store_rejected"].(bool); ok && !storeRejected {
+		return nil
+	}
+	s.store = common.GetConfig().Store
+	return nil
+}
+
+func (s *service) Run(ctx context.Context, in <-chan common.Event, out chan<- common.Event) error {
+	return common.ProcessEvents(ctx, in, out, func(event common.Event) []common.Event {
+		failures := Validate(event, Schemas)
+		if failures == nil {
+			return []common.Event{event}
+		}
+		fmt.Printf("Rejected %s event: %v\n", event.Type, failures)
+		if s.store != nil {
+			rejected := Reject(event, failures)
+			s.store.InsertDocument(rejected.Type, rejected.Document())
+		}
+		return nil
+	})
+}
+
+func (s *service) Close() error { return nil }
@@ -0,0 +1,106 @@
+package validate
+
+import (
+	"bytes"
+	"clutch/common"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Events failing their schema are stored in this index instead of their own
+const RejectedIndex = "rejected_events"
+
+// Schemas holds the compiled JSON Schema of every event type
+var Schemas = make(map[string]*jsonschema.Schema)
+
+// Failure is one reason an event does not match its schema
+type Failure struct {
+	// JSON pointer to the offending value, "" for the payload itself
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// LoadSchemas compiles the schemas/<type>_schema.json files
+func LoadSchemas(schemas map[string]*jsonschema.Schema) {
+	fmt.Println("Loading schemas")
+	files, err := filepath.Glob("schemas/*_schema.json")
+	if err != nil {
+		fmt.Println("Error getting file paths:", err)
+	}
+	for _, file := range files {
+		schema, err := jsonschema.Compile(file)
+		if err != nil {
+			fmt.Println("Error compiling schema:", err)
+			continue
+		}
+		eventType := strings.TrimSuffix(filepath.Base(file), "_schema.json")
+		schemas[eventType] = schema
+	}
+	fmt.Println("Loaded schemas for:", len(schemas), "event types")
+}
+
+// Validate checks the payload against the schema of the event type, events without
+// a schema always pass
+func Validate(event common.Event, schemas map[string]*jsonschema.Schema) []Failure {
+	schema, ok := schemas[event.Type]
+	if !ok {
+		return nil
+	}
+	// The validator only knows the types encoding/json decodes to, payloads from
+	// msgpack, protobuf or the parsers are brought into that shape first
+	data, err := json.Marshal(event.Payload)
+	if err != nil {
+		return []Failure{{Message: err.Error()}}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var payload interface{}
+	if err := decoder.Decode(&payload); err != nil {
+		return []Failure{{Message: err.Error()}}
+	}
+
+	err = schema.Validate(payload)
+	if err == nil {
+		return nil
+	}
+	var validationError *jsonschema.ValidationError
+	if !errors.As(err, &validationError) {
+		return []Failure{{Message: err.Error()}}
+	}
+	return failures(validationError, nil)
+}
+
+// failures keeps the innermost errors, the outer ones only say a subschema failed
+func failures(err *jsonschema.ValidationError, found []Failure) []Failure {
+	if len(err.Causes) == 0 {
+		return append(found, Failure{Path: err.InstanceLocation, Message: err.Message})
+	}
+	for _, cause := range err.Causes {
+		found = failures(cause, found)
+	}
+	return found
+}
+
+// Reject wraps an invalid event for the rejected index. The payload is kept as JSON
+// text, its fields would clash with the mappings of the other types in the index.
+func Reject(event common.Event, found []Failure) common.Event {
+	payload, _ := json.Marshal(event.Payload)
+	reasons := make([]interface{}, len(found))
+	for i, failure := range found {
+		reasons[i] = map[string]interface{}{"path": failure.Path, "message": failure.Message}
+	}
+	return common.Event{
+		Type: RejectedIndex,
+		Payload: common.M{
+			"event_type": event.Type,
+			"payload":    string(payload),
+			"errors":     reasons,
+		},
+		Meta: event.Meta,
+	}
+}
@@ -0,0 +1,81 @@
+package validate
+
+import (
+	"clutch/common"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+const sensorSchema = `{
+	"type": "object",
+	"required": ["machine_id"],
+	"properties": {
+		"machine_id": {"type": "string"},
+		"rpm": {"type": "number", "minimum": 0},
+		"engine": {"type": "object", "properties": {"temp": {"type": "integer"}}}
+	}
+}`
+
+func TestValidate(t *testing.T) {
+	schemas := map[string]*jsonschema.Schema{
+		"sensor": jsonschema.MustCompileString("sensor_schema.json", sensorSchema),
+	}
+	tests := []struct {
+		name    string
+		event   common.Event
+		want    []string
+		invalid bool
+	}{
+		{"valid", common.Event{Type: "sensor", Payload: common.M{"machine_id": "4", "rpm": json.Number("1800.5")}}, nil, false},
+		{"no schema", common.Event{Type: "billing", Payload: common.M{"machine_id": 4}}, nil, false},
+		{"missing field", common.Event{Type: "sensor", Payload: common.M{"rpm": 10}}, []string{""}, true},
+		{"wrong types", common.Event{Type: "sensor", Payload: common.M{"machine_id": 4, "rpm": "fast"}}, []string{"/machine_id", "/rpm"}, true},
+		{"nested", common.Event{Type: "sensor", Payload: common.M{"machine_id": "4", "engine": map[string]interface{}{"temp": 80.5}}}, []string{"/engine/temp"}, true},
+		{"below minimum", common.Event{Type: "sensor", Payload: common.M{"machine_id": "4", "rpm": int64(-1)}}, []string{"/rpm"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failures := Validate(tt.event, schemas)
+			if (failures != nil) != tt.invalid {
+				t.Fatalf("Validate() = %v, invalid %v", failures, tt.invalid)
+			}
+			paths := map[string]bool{}
+			for _, failure := range failures {
+				if failure.Message == "" {
+					t.Errorf("failure without a message: %+v", failure)
+				}
+				paths[failure.Path] = true
+			}
+			for _, path := range tt.want {
+				if !paths[path] {
+					t.Errorf("no failure for %q in %+v", path, failures)
+				}
+			}
+		})
+	}
+}
+
+func TestReject(t *testing.T) {
+	event := common.Event{Type: "sensor", Payload: common.M{"machine_id": 4}, Meta: common.NewEventMeta("default", "")}
+	rejected := Reject(event, []Failure{{Path: "/machine_id", Message: "expected string, but got number"}})
+	if rejected.Type != RejectedIndex || rejected.Meta.ID != event.Meta.ID {
+		t.Errorf("rejected = %+v", rejected)
+	}
+	want := common.M{
+		"event_type": "sensor",
+		"payload":    `{"machine_id":4}`,
+		"errors":     []interface{}{map[string]interface{}{"path": "/machine_id", "message": "expected string, but got number"}},
+	}
+	if !reflect.DeepEqual(rejected.Payload, want) {
+		t.Errorf("payload = %v, want %v", rejected.Payload, want)
+	}
+}
+
+func TestShippedSchemasCompile(t *testing.T) {
+	if _, err := jsonschema.Compile("../../schemas/clutch_testing_events_schema.json"); err != nil {
+		t.Error(err)
+	}
+}
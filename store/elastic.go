@@ -10,7 +10,10 @@ import (
 	"reflect"
 	"strings"
 
+	"clutch/common"
+
 	elasticsearch8 "github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
 )
 
 type ElasticStore struct {
@@ -150,7 +153,14 @@ func (c *ElasticStore) CreateIndices(indicies ...Index) {
 func (c *ElasticStore) InsertDocument(index string, body map[string]interface{}) {
 	doc := body
 	docJSON, _ := json.Marshal(doc)
-	res, err := c.es.Index(index, bytes.NewReader(docJSON))
+	// The event id doubles as the document id, so storing an event twice does not duplicate it
+	var options []func(*esapi.IndexRequest)
+	if meta, ok := body[common.MetaField].(map[string]interface{}); ok {
+		if id, ok := meta["id"].(string); ok && id != "" {
+			options = append(options, c.es.Index.WithDocumentID(id))
+		}
+	}
+	res, err := c.es.Index(index, bytes.NewReader(docJSON), options...)
 	if err != nil {
 		log.Fatalf("Error indexing document: %s", err)
 	}
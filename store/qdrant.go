@@ -368,3 +368,11 @@ func (s *QdrantStore) GetResults(searchResult map[string]interface{}) (res []map
 // 	}
 // 	fmt.Println(operationInfo)
 // }
+
+// Close closes the connection, once nothing writes anymore
+func (s *QdrantStore) Close() error {
+	if s.Client == nil {
+		return nil
+	}
+	return s.Client.Close()
+}
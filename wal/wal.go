@@ -0,0 +1,441 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"clutch/common"
+)
+
+const (
+	// Used when the config sets no segment_size
+	DefaultSegmentSize = 64 << 20
+	// Anything bigger is a corrupt length, the ingest endpoints never accept this much
+	maxRecordSize = 64 << 20
+	// length, crc32 of the rest, sequence number. A record without a body marks the
+	// event with that number as committed.
+	headerSize    = 16
+	segmentSuffix = ".wal"
+)
+
+var errCorrupt = errors.New("corrupt record")
+
+// record is what is written for every event, the envelope is part of it so a
+// replayed event is stored under the same id
+type record struct {
+	Type       string    `json:"type"`
+	Payload    common.M  `json:"payload"`
+	ID         string    `json:"id"`
+	ReceivedAt time.Time `json:"received_at"`
+	Listener   string    `json:"listener,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	Identity   string    `json:"identity,omitempty"`
+}
+
+// segment is one file of the log, named after the sequence number of its first event
+type segment struct {
+	path  string
+	first uint64
+	// Sequence number of its newest event
+	last uint64
+	// Events in the file that are not committed yet
+	pending map[uint64]bool
+	// Older segments this file holds commits for, by their first sequence number. Their
+	// events would be replayed if the file went before them.
+	refs map[uint64]bool
+}
+
+// Log appends events to segment files and deletes a file once all of its events are
+// committed and no older file needs its commits. Whatever is left when the process
+// stops is replayed on the next start.
+type Log struct {
+	dir         string
+	segmentSize int64
+	sync        bool
+
+	mutex sync.Mutex
+	// Oldest first, while file is open the last one is written to
+	segments []*segment
+	file     *os.File
+	size     int64
+	next     uint64
+	// Segments left by the previous run, read again by Replay
+	recovered []*segment
+}
+
+// Open reads the segments left in the directory and starts a new one for appends
+func Open(cfg common.WALConfig) (*Log, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating write-ahead log directory: %w", err)
+	}
+	l := &Log{dir: cfg.Dir, segmentSize: cfg.SegmentSize, sync: cfg.Sync, next: 1}
+	if l.segmentSize <= 0 {
+		l.segmentSize = DefaultSegmentSize
+	}
+
+	paths, err := filepath.Glob(filepath.Join(cfg.Dir, "*"+segmentSuffix))
+	if err != nil {
+		return nil, err
+	}
+	// The names are zero padded, so this is also the order they were written in
+	sort.Strings(paths)
+	committed := make(map[uint64]bool)
+	// Commits of events older than the segment they are in
+	older := make(map[*segment][]uint64)
+	for _, path := range paths {
+		first, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), segmentSuffix), 10, 64)
+		if err != nil {
+			fmt.Println("Skipping unknown file in the write-ahead log:", path)
+			continue
+		}
+		s := &segment{path: path, first: first, pending: make(map[uint64]bool), refs: make(map[uint64]bool)}
+		err = readSegment(path, func(seq uint64, body []byte) {
+			if len(body) == 0 {
+				committed[seq] = true
+				if seq < first {
+					older[s] = append(older[s], seq)
+				}
+				return
+			}
+			s.pending[seq] = true
+			if seq > s.last {
+				s.last = seq
+			}
+			if seq >= l.next {
+				l.next = seq + 1
+			}
+		})
+		if errors.Is(err, errCorrupt) {
+			// Usually the last write before a crash, nothing after it was acknowledged
+			fmt.Printf("Ignoring the end of %s after %d events: %v\n", path, len(s.pending), err)
+		} else if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", path, err)
+		}
+		l.segments = append(l.segments, s)
+	}
+	// Commits are written to the segment that was current at the time, the same one
+	// as the event or a newer one
+	for _, s := range l.segments {
+		for seq := range s.pending {
+			if committed[seq] {
+				delete(s.pending, seq)
+			}
+		}
+		for _, seq := range older[s] {
+			if i := l.find(seq); i >= 0 {
+				s.refs[l.segments[i].first] = true
+			}
+		}
+	}
+	l.prune()
+	l.recovered = append([]*segment(nil), l.segments...)
+	return l, nil
+}
+
+// Replay calls fn with every event the previous run did not commit, in the order they
+// were logged. The events keep their sequence number and must be committed as usual.
+func (l *Log) Replay(fn func(event common.Event)) (int, error) {
+	l.mutex.Lock()
+	recovered := l.recovered
+	l.recovered = nil
+	l.mutex.Unlock()
+
+	replayed := 0
+	for _, s := range recovered {
+		var decodeErr error
+		err := readSegment(s.path, func(seq uint64, body []byte) {
+			if !l.pending(s, seq) {
+				return
+			}
+			event, err := decodeRecord(body)
+			if err != nil {
+				decodeErr = err
+				l.Commit(seq) // it would fail again on every start
+				return
+			}
+			event.Meta.Seq = seq
+			fn(event)
+			replayed++
+		})
+		if decodeErr != nil {
+			fmt.Printf("Error decoding events in %s: %v\n", s.path, decodeErr)
+		}
+		if err != nil && !errors.Is(err, errCorrupt) {
+			return replayed, fmt.Errorf("error reading %s: %w", s.path, err)
+		}
+	}
+	return replayed, nil
+}
+
+// Append logs the event and returns its sequence number. With sync set the event is
+// on disk when it returns.
+func (l *Log) Append(event common.Event) (uint64, error) {
+	body, err := json.Marshal(record{
+		Type:       event.Type,
+		Payload:    event.Payload,
+		ID:         event.Meta.ID,
+		ReceivedAt: event.Meta.ReceivedAt,
+		Listener:   event.Meta.Listener,
+		RemoteAddr: event.Meta.RemoteAddr,
+		Identity:   event.Meta.Identity,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(body) > maxRecordSize {
+		return 0, fmt.Errorf("event of %d bytes is too large for the write-ahead log", len(body))
+	}
+	frame := make([]byte, headerSize+len(body))
+	copy(frame[headerSize:], body)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil || l.size >= l.segmentSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	seq := l.next
+	binary.BigEndian.PutUint32(frame[0:], uint32(len(body)))
+	binary.BigEndian.PutUint64(frame[8:], seq)
+	binary.BigEndian.PutUint32(frame[4:], crc32.ChecksumIEEE(frame[8:]))
+	if _, err := l.file.Write(frame); err != nil {
+		// The file may end in half a record now, later events go to a new one
+		l.closeActive()
+		return 0, fmt.Errorf("error writing to the write-ahead log: %w", err)
+	}
+	if l.sync {
+		if err := l.file.Sync(); err != nil {
+			l.closeActive()
+			return 0, fmt.Errorf("error syncing the write-ahead log: %w", err)
+		}
+	}
+	l.next++
+	l.size += int64(len(frame))
+	s := l.segments[len(l.segments)-1]
+	s.pending[seq] = true
+	s.last = seq
+	return seq, nil
+}
+
+// Commit marks an event as done, its segment is deleted with the last one. Until then
+// the commit is written down so the event is not replayed.
+func (l *Log) Commit(seq uint64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	i := l.find(seq)
+	if i < 0 || !l.segments[i].pending[seq] {
+		return
+	}
+	s := l.segments[i]
+	delete(s.pending, seq)
+	if len(s.pending) == 0 {
+		l.prune()
+		if i = l.find(seq); i < 0 || l.segments[i] != s {
+			return
+		}
+	}
+	if l.file != nil {
+		// Losing a marker only means the event is replayed once more
+		if err := l.writeCommit(seq); err != nil {
+			fmt.Println("Error writing commit to the write-ahead log:", err)
+			l.closeActive()
+			return
+		}
+		if last := l.segments[len(l.segments)-1]; last != s {
+			last.refs[s.first] = true
+		}
+	}
+}
+
+func (l *Log) writeCommit(seq uint64) error {
+	frame := make([]byte, headerSize)
+	binary.BigEndian.PutUint64(frame[8:], seq)
+	binary.BigEndian.PutUint32(frame[4:], crc32.ChecksumIEEE(frame[8:]))
+	if _, err := l.file.Write(frame); err != nil {
+		return err
+	}
+	l.size += headerSize
+	return nil
+}
+
+// find returns the index of the segment an event was written to, -1 if there is none
+func (l *Log) find(seq uint64) int {
+	return sort.Search(len(l.segments), func(i int) bool { return l.segments[i].first > seq }) - 1
+}
+
+func (l *Log) pending(s *segment, seq uint64) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return s.pending[seq]
+}
+
+// Close closes the segment being written, what is committed by then is deleted
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	err := l.closeActive()
+	pending := 0
+	for _, s := range l.segments {
+		pending += len(s.pending)
+	}
+	if pending > 0 {
+		fmt.Printf("Write-ahead log closed with %d events to replay\n", pending)
+	}
+	return err
+}
+
+// rotate closes the current segment and starts a new one at the next sequence number
+func (l *Log) rotate() error {
+	if err := l.closeActive(); err != nil {
+		fmt.Println("Error closing write-ahead log segment:", err)
+	}
+	path := filepath.Join(l.dir, fmt.Sprintf("%020d%s", l.next, segmentSuffix))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("error creating write-ahead log segment: %w", err)
+	}
+	l.file = file
+	l.size = 0
+	l.segments = append(l.segments, &segment{path: path, first: l.next, pending: make(map[uint64]bool), refs: make(map[uint64]bool)})
+	return nil
+}
+
+// closeActive stops writing to the last segment and deletes it if nothing in it is pending
+func (l *Log) closeActive() error {
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	l.prune()
+	return err
+}
+
+// prune deletes the segments without pending events, except the one being written. A
+// segment holding commits of older ones waits until those are gone, or until their
+// commits are written again to the segment being written. Otherwise one event that
+// is never committed would keep every newer segment, each waiting for the one before.
+func (l *Log) prune() {
+	present := make(map[uint64]bool, len(l.segments))
+	for _, s := range l.segments {
+		present[s.first] = true
+	}
+	for i := 0; i < len(l.segments); i++ {
+		s := l.segments[i]
+		for first := range s.refs {
+			if !present[first] {
+				delete(s.refs, first)
+			}
+		}
+		active := l.file != nil && i == len(l.segments)-1
+		if len(s.pending) > 0 || active || (len(s.refs) > 0 && !l.carry(s)) {
+			continue
+		}
+		delete(present, s.first)
+		l.remove(i)
+		i--
+	}
+}
+
+// carry writes the commits s holds for older segments to the segment being written,
+// so s can go. prune goes oldest first, so the older segments still have pending events.
+func (l *Log) carry(s *segment) bool {
+	if l.file == nil {
+		return false
+	}
+	last := l.segments[len(l.segments)-1]
+	for first := range s.refs {
+		older := l.segments[l.find(first)]
+		for seq := older.first; seq <= older.last; seq++ {
+			if older.pending[seq] {
+				continue
+			}
+			if err := l.writeCommit(seq); err != nil {
+				// prune is running already, it deletes the file if nothing in it is pending
+				fmt.Println("Error writing commit to the write-ahead log:", err)
+				l.file.Close()
+				l.file = nil
+				return false
+			}
+		}
+		last.refs[first] = true
+	}
+	return true
+}
+
+func (l *Log) remove(i int) {
+	if err := os.Remove(l.segments[i].path); err != nil {
+		fmt.Println("Error removing write-ahead log segment:", err)
+	}
+	l.segments = append(l.segments[:i], l.segments[i+1:]...)
+}
+
+// readSegment calls fn for every record of the file and stops at the first one that
+// is cut short or does not match its checksum
+func readSegment(path string, fn func(seq uint64, body []byte)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	header := make([]byte, headerSize)
+	for {
+		if _, err := io.ReadFull(reader, header); err == io.EOF {
+			return nil
+		} else if err == io.ErrUnexpectedEOF {
+			return errCorrupt
+		} else if err != nil {
+			return err
+		}
+		size := binary.BigEndian.Uint32(header[0:])
+		if size > maxRecordSize {
+			return errCorrupt
+		}
+		data := make([]byte, 8+size)
+		copy(data, header[8:])
+		if _, err := io.ReadFull(reader, data[8:]); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errCorrupt
+		} else if err != nil {
+			return err
+		}
+		if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) {
+			return errCorrupt
+		}
+		fn(binary.BigEndian.Uint64(data), data[8:])
+	}
+}
+
+func decodeRecord(body []byte) (common.Event, error) {
+	var r record
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber() // the same numbers the ingest endpoints decode
+	if err := decoder.Decode(&r); err != nil {
+		return common.Event{}, err
+	}
+	return common.Event{
+		Type:    r.Type,
+		Payload: r.Payload,
+		Meta: common.EventMeta{
+			ID:         r.ID,
+			ReceivedAt: r.ReceivedAt,
+			Listener:   r.Listener,
+			RemoteAddr: r.RemoteAddr,
+			Identity:   r.Identity,
+		},
+	}, nil
+}
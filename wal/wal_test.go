@@ -0,0 +1,215 @@
+package wal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"clutch/common"
+)
+
+func openLog(t *testing.T, cfg common.WALConfig) *Log {
+	t.Helper()
+	l, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func appendEvent(t *testing.T, l *Log, eventType string) uint64 {
+	t.Helper()
+	event := common.Event{Type: eventType, Payload: common.M{"value": 1.5}, Meta: common.NewEventMeta("test", "127.0.0.1:1")}
+	seq, err := l.Append(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return seq
+}
+
+func replay(t *testing.T, l *Log) []common.Event {
+	t.Helper()
+	var events []common.Event
+	if _, err := l.Replay(func(event common.Event) { events = append(events, event) }); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func segments(t *testing.T, dir string) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestReplay(t *testing.T) {
+	cfg := common.WALConfig{Dir: t.TempDir(), Sync: true}
+	l := openLog(t, cfg)
+	meta := common.EventMeta{ID: "abc", ReceivedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Listener: "ingest", Identity: "ops"}
+	if _, err := l.Append(common.Event{Type: "a", Payload: common.M{"count": 3}, Meta: meta}); err != nil {
+		t.Fatal(err)
+	}
+	committed := appendEvent(t, l, "b")
+	appendEvent(t, l, "c")
+	l.Commit(committed)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	l = openLog(t, cfg)
+	events := replay(t, l)
+	if len(events) != 2 || events[0].Type != "a" || events[1].Type != "c" {
+		t.Fatalf("replayed %+v", events)
+	}
+	want := meta
+	want.Seq = 1
+	if events[0].Meta != want || events[0].Payload["count"] != json.Number("3") {
+		t.Errorf("replayed %+v", events[0])
+	}
+	// New events continue after the replayed ones
+	if seq := appendEvent(t, l, "d"); seq != 4 {
+		t.Errorf("seq = %d, want 4", seq)
+	}
+	if events := replay(t, l); len(events) != 0 {
+		t.Errorf("replayed twice: %+v", events)
+	}
+}
+
+func TestCommitRemovesSegments(t *testing.T) {
+	dir := t.TempDir()
+	// Every event gets a segment of its own
+	l := openLog(t, common.WALConfig{Dir: dir, SegmentSize: 1})
+	first, second, third := appendEvent(t, l, "a"), appendEvent(t, l, "a"), appendEvent(t, l, "a")
+	if found := segments(t, dir); len(found) != 3 {
+		t.Fatalf("segments = %v", found)
+	}
+
+	l.Commit(second)
+	l.Commit(second)
+	if found := segments(t, dir); len(found) != 2 {
+		t.Errorf("segments after a commit = %v", found)
+	}
+	// The segment being written is kept until the next one starts
+	l.Commit(third)
+	if found := segments(t, dir); len(found) != 2 {
+		t.Errorf("segments after committing the last event = %v", found)
+	}
+	l.Commit(first)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if found := segments(t, dir); len(found) != 0 {
+		t.Errorf("segments after close = %v", found)
+	}
+}
+
+func TestCommitsOutliveOlderSegments(t *testing.T) {
+	cfg := common.WALConfig{Dir: t.TempDir()}
+	l := openLog(t, cfg)
+	first, second := appendEvent(t, l, "a"), appendEvent(t, l, "b")
+	// Every event from here on gets a segment of its own
+	l.segmentSize = 1
+	third := appendEvent(t, l, "c")
+	// The commit of the first event is written to the segment of the third
+	l.Commit(first)
+	l.Commit(third)
+	appendEvent(t, l, "d")
+	if found := segments(t, cfg.Dir); len(found) != 3 {
+		t.Errorf("segments = %v, the one holding the commit should stay", found)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	l = openLog(t, cfg)
+	events := replay(t, l)
+	if len(events) != 2 || events[0].Type != "b" || events[1].Type != "d" {
+		t.Fatalf("replayed %+v", events)
+	}
+	// Once the oldest segment goes, the one holding its commits can go as well
+	l.Commit(second)
+	if found := segments(t, cfg.Dir); len(found) != 1 {
+		t.Errorf("segments after committing the oldest = %v", found)
+	}
+	l.Close()
+}
+
+func TestHeldEventKeepsFewSegments(t *testing.T) {
+	cfg := common.WALConfig{Dir: t.TempDir(), SegmentSize: 200}
+	// Two events to a segment
+	l := openLog(t, cfg)
+	appendEvent(t, l, "held")
+	// Events are committed a little late, so their commits land in newer segments
+	var seqs []uint64
+	for i := 0; i < 100; i++ {
+		seqs = append(seqs, appendEvent(t, l, "a"))
+		if i >= 3 {
+			l.Commit(seqs[i-3])
+		}
+	}
+	// Only the held event and the last few are left
+	if found := segments(t, cfg.Dir); len(found) > 5 {
+		t.Errorf("%d segments left behind one held event", len(found))
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The commits written again still keep the committed events from coming back
+	l = openLog(t, cfg)
+	events := replay(t, l)
+	if len(events) != 4 || events[0].Type != "held" || events[1].Meta.Seq != seqs[97] {
+		t.Errorf("replayed %d events, want the held one and the last three", len(events))
+	}
+	l.Close()
+}
+
+func TestTornSegment(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+	}{
+		{"cut short", func(data []byte) []byte { return data[:len(data)-3] }},
+		{"bad checksum", func(data []byte) []byte {
+			data[len(data)-2] ^= 0xff
+			return data
+		}},
+		{"bad length", func(data []byte) []byte {
+			return append(data, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 9)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := common.WALConfig{Dir: t.TempDir()}
+			l := openLog(t, cfg)
+			appendEvent(t, l, "a")
+			appendEvent(t, l, "b")
+			l.Close()
+
+			path := segments(t, cfg.Dir)[0]
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, tt.corrupt(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			l = openLog(t, cfg)
+			defer l.Close()
+			events := replay(t, l)
+			want := 1
+			if tt.name == "bad length" {
+				want = 2
+			}
+			if len(events) != want || events[0].Type != "a" {
+				t.Errorf("replayed %+v", events)
+			}
+		})
+	}
+}